@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	letsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeCheckInterval    = 12 * time.Hour
+	acmeRetryInterval    = time.Hour
+	acmeRequestTimeout   = 10 * time.Minute
+)
+
+// ACMEConfig obtains the web interface's certificate from an ACME CA such
+// as Let's Encrypt, proving control of the names with DNS-01 challenges
+// published through CloudFlare
+type ACMEConfig struct {
+	Domains         []string `json:"domains,omitempty"`   // Names on the certificate; empty turns ACME off
+	Email           string   `json:"email,omitempty"`     // Contact for expiry notices from the CA
+	DirectoryURL    string   `json:"directory_url"`       // Defaults to Let's Encrypt
+	CAFile          string   `json:"ca_file,omitempty"`   // Extra root CA for the ACME server, e.g. Pebble's
+	APIToken        string   `json:"api_token,omitempty"` // Token for the challenge records; defaults to the zone's record token
+	RenewBeforeDays int      `json:"renew_before_days"`   // Renew when the certificate expires within this many days
+	PropagationWait int      `json:"propagation_wait"`    // Seconds to wait after publishing a challenge record
+}
+
+// Enabled reports whether certificates are obtained with ACME
+func (c ACMEConfig) Enabled() bool {
+	return len(c.Domains) > 0
+}
+
+// ACMEPaths returns the ACME account key and the issued certificate and key
+func (c *AppConfig) ACMEPaths() (accountKey, certFile, keyFile string) {
+	dir := sidecarPath(configPath, ".acme")
+	return filepath.Join(dir, "account.key"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+// validateACME checks the ACME settings
+func validateACME(c *AppConfig, errs *ValidationErrors) {
+	cfg := c.Web.TLS.ACME
+	if !cfg.Enabled() {
+		return
+	}
+
+	if c.Web.TLS.CertFile != "" || c.Web.TLS.SelfSigned {
+		errs.add("$.web.tls.acme", "cannot be combined with cert_file or self_signed")
+	}
+	for i, domain := range cfg.Domains {
+		if strings.TrimSpace(domain) == "" || strings.Count(strings.TrimPrefix(domain, "*."), ".") < 1 {
+			errs.add(fmt.Sprintf("$.web.tls.acme.domains[%d]", i), "must be a domain name such as ddns.example.com, got %q", domain)
+		}
+	}
+	if parsed, err := url.Parse(cfg.DirectoryURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		errs.add("$.web.tls.acme.directory_url", "must be an https URL, got %q", cfg.DirectoryURL)
+	}
+	if cfg.RenewBeforeDays < 1 {
+		errs.add("$.web.tls.acme.renew_before_days", "must be at least 1, got %d", cfg.RenewBeforeDays)
+	}
+	if cfg.PropagationWait < 0 {
+		errs.add("$.web.tls.acme.propagation_wait", "must not be negative, got %d", cfg.PropagationWait)
+	}
+}
+
+// runACME keeps the ACME certificate current: it requests one at startup if
+// there is none and renews it when it nears expiry, until shutdown
+func (p *DDNSPilot) runACME() {
+	for {
+		wait := acmeCheckInterval
+		if reason := p.certificateDue(); reason != "" {
+			slog.Info("Requesting certificate", "domains", strings.Join(p.config.Web.TLS.ACME.Domains, ","), "reason", reason)
+
+			ctx, cancel := context.WithTimeout(p.ctx, acmeRequestTimeout)
+			expires, err := p.obtainCertificate(ctx)
+			cancel()
+
+			if err != nil {
+				slog.Error("Certificate request failed", "retry_in", acmeRetryInterval, "error", err)
+				wait = acmeRetryInterval
+			} else {
+				slog.Info("Certificate issued", "expires", expires.Format(time.DateOnly))
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-p.stopping.Done():
+			return
+		}
+	}
+}
+
+// certificateDue explains why a new certificate is needed, or returns ""
+func (p *DDNSPilot) certificateDue() string {
+	cfg := p.config.Web.TLS.ACME
+	_, certFile, keyFile := p.config.ACMEPaths()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return "no certificate yet"
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "unreadable certificate"
+	}
+	if time.Until(leaf.NotAfter) < time.Duration(cfg.RenewBeforeDays)*24*time.Hour {
+		return "expires " + leaf.NotAfter.Format(time.DateOnly)
+	}
+
+	names := make(map[string]bool, len(leaf.DNSNames))
+	for _, name := range leaf.DNSNames {
+		names[strings.ToLower(name)] = true
+	}
+	for _, domain := range cfg.Domains {
+		if !names[strings.ToLower(domain)] {
+			return "domains changed"
+		}
+	}
+	return ""
+}
+
+// obtainCertificate runs one ACME order: it answers a DNS-01 challenge for
+// each domain, then stores the certificate where the web listener picks it
+// up. It returns when the new certificate expires.
+func (p *DDNSPilot) obtainCertificate(ctx context.Context) (time.Time, error) {
+	cfg := p.config.Web.TLS.ACME
+	accountKeyFile, certFile, keyFile := p.config.ACMEPaths()
+
+	if err := os.MkdirAll(filepath.Dir(accountKeyFile), 0700); err != nil {
+		return time.Time{}, fmt.Errorf("failed to create ACME directory: %v", err)
+	}
+	accountKey, err := loadOrCreateKey(accountKeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	httpClient, err := acmeHTTPClient(cfg.CAFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: cfg.DirectoryURL, HTTPClient: httpClient}
+
+	account := &acme.Account{}
+	if cfg.Email != "" {
+		account.Contact = []string{"mailto:" + cfg.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return time.Time{}, fmt.Errorf("failed to register ACME account: %v", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(cfg.Domains...))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create order: %v", err)
+	}
+
+	// Only the response creating the order is sure to carry its URL
+	orderURL := order.URI
+	for _, authzURL := range order.AuthzURLs {
+		if err := p.authorize(ctx, client, authzURL); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("order not ready: %v", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(cfg.Domains[0], "*.")},
+		DNSNames: cfg.Domains,
+	}, certKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// CAs such as Pebble answer a finalization that is still processing
+		// without the order URL the client would wait on, so wait here
+		if current, getErr := client.GetOrder(ctx, orderURL); getErr == nil && (current.Status == acme.StatusProcessing || current.Status == acme.StatusValid) {
+			if current, err = client.WaitOrder(ctx, orderURL); err == nil {
+				chain, err = client.FetchCert(ctx, current.CertURL, true)
+			}
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to finalize order: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(certKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to encode key: %v", err)
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := writeKeyPair(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return time.Time{}, err
+	}
+
+	return leaf.NotAfter, nil
+}
+
+// authorize proves control of one domain with a DNS-01 challenge. The
+// challenge record is removed again whatever the outcome.
+func (p *DDNSPilot) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %v", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	domain := authz.Identifier.Value
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no DNS-01 challenge offered for %s", domain)
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("failed to compute challenge for %s: %v", domain, err)
+	}
+
+	apiToken, zoneID, err := p.challengeZone(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	name := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+	recordID, err := p.ddns.CreateTXTRecord(ctx, apiToken, zoneID, name, value)
+	if err != nil {
+		return fmt.Errorf("failed to publish %s: %v", name, err)
+	}
+	slog.Info("Published ACME challenge", "record", name, "zone", zoneID)
+	defer func() {
+		// Clean up even when the request was cancelled
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := p.ddns.DeleteDNSRecord(cleanupCtx, apiToken, zoneID, recordID); err != nil {
+			slog.Warn("Failed to remove ACME challenge", "record", name, "zone", zoneID, "error", err)
+		}
+	}()
+
+	// Give the record time to reach every CloudFlare name server
+	select {
+	case <-time.After(time.Duration(p.config.Web.TLS.ACME.PropagationWait) * time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("failed to accept challenge for %s: %v", domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("authorization for %s failed: %v", domain, err)
+	}
+	return nil
+}
+
+// challengeZone picks the API token and zone for a domain's challenge
+// record: the ACME token or that of a managed record in the same zone,
+// falling back to the default token. The zone ID is taken from a record
+// using the chosen token, and looked up otherwise.
+func (p *DDNSPilot) challengeZone(ctx context.Context, domain string) (apiToken, zoneID string, err error) {
+	zoneName, err := p.ddns.ExtractZoneName(strings.TrimPrefix(domain, "*."))
+	if err != nil {
+		return "", "", err
+	}
+
+	apiToken = p.config.Web.TLS.ACME.APIToken
+	for _, record := range p.config.Records {
+		recordZone, err := p.ddns.ExtractZoneName(record.RecordName)
+		if err != nil || !strings.EqualFold(recordZone, zoneName) {
+			continue
+		}
+		if apiToken == "" {
+			apiToken = record.APIToken
+		}
+		if apiToken == record.APIToken && record.ZoneID != "" {
+			zoneID = record.ZoneID
+			break
+		}
+	}
+	if apiToken == "" {
+		apiToken = p.config.DefaultAPIToken
+	}
+	if apiToken == "" {
+		return "", "", fmt.Errorf("no API token for zone %s (set web.tls.acme.api_token or default_api_token)", zoneName)
+	}
+
+	if zoneID == "" {
+		if zoneID, err = p.ddns.GetZoneID(ctx, apiToken, zoneName); err != nil {
+			return "", "", fmt.Errorf("failed to get zone ID: %v", err)
+		}
+	}
+	return apiToken, zoneID, nil
+}
+
+// acmeHTTPClient trusts the system roots plus caFile, if given
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caFile == "" {
+		return client, nil
+	}
+
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	client.Transport = transport
+	return client, nil
+}
+
+// loadOrCreateKey reads an ECDSA key, generating and saving one if the
+// file does not exist
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key: %v", err)
+		}
+		return key, writePEM(path, "PRIVATE KEY", der, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no key found in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold an ECDSA key", path)
+	}
+	return key, nil
+}
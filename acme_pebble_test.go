@@ -0,0 +1,109 @@
+//go:build pebble
+
+// Integration test against a local Pebble ACME server. Start Pebble with
+// PEBBLE_VA_ALWAYS_VALID=1 and run
+//
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA_FILE=pebble.minica.pem go test -tags pebble -run Pebble
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObtainCertificatePebble(t *testing.T) {
+	directory, caFile := os.Getenv("PEBBLE_DIRECTORY"), os.Getenv("PEBBLE_CA_FILE")
+	if directory == "" || caFile == "" {
+		t.Skip("set PEBBLE_DIRECTORY and PEBBLE_CA_FILE to run against Pebble")
+	}
+
+	// CloudFlare stub that keeps the TXT records published for challenges
+	var mutex sync.Mutex
+	published := make(map[string]string)
+	var created, deleted int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var result any
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/zones/zone1/dns_records":
+			var record CloudFlareRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			created++
+			record.ID = fmt.Sprintf("txt%d", created)
+			published[record.ID] = record.Name
+			result = record
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/"):
+			delete(published, strings.TrimPrefix(r.URL.Path, "/zones/zone1/dns_records/"))
+			deleted++
+			result = map[string]string{}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(CloudFlareResponse{Errors: []CloudFlareError{{Code: 7003, Message: "No route"}}})
+			return
+		}
+		data, _ := json.Marshal(result)
+		json.NewEncoder(w).Encode(CloudFlareResponse{Success: true, Result: data})
+	}))
+	defer server.Close()
+
+	previousBase, previousPath := cloudflareAPIBase, configPath
+	cloudflareAPIBase = server.URL
+	configPath = filepath.Join(t.TempDir(), "ddns-pilot.json")
+	defer func() { cloudflareAPIBase, configPath = previousBase, previousPath }()
+
+	config := newDefaultConfig()
+	config.Records = []DDNSRecord{{RecordName: "home.example.com", APIToken: "token", ZoneID: "zone1"}}
+	config.Web.TLS.ACME = ACMEConfig{
+		Domains:         []string{"ddns.example.com", "*.example.com"},
+		DirectoryURL:    directory,
+		CAFile:          caFile,
+		RenewBeforeDays: 1, // Pebble may issue short-lived certificates
+	}
+	p := &DDNSPilot{config: config, ddns: &DDNSManager{config: config}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	expires, err := p.obtainCertificate(ctx)
+	if err != nil {
+		t.Fatalf("obtainCertificate: %v", err)
+	}
+	if time.Until(expires) < 24*time.Hour {
+		t.Errorf("certificate expires %v", expires)
+	}
+
+	_, certFile, keyFile := config.ACMEPaths()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(leaf.DNSNames)
+	if want := []string{"*.example.com", "ddns.example.com"}; !slices.Equal(leaf.DNSNames, want) {
+		t.Errorf("certificate names = %v, want %v", leaf.DNSNames, want)
+	}
+	if reason := p.certificateDue(); reason != "" {
+		t.Errorf("new certificate is due: %s", reason)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if created == 0 || created != deleted || len(published) != 0 {
+		t.Errorf("challenge records: %d created, %d deleted, left %v", created, deleted, published)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubCloudFlareZones answers zone lookups for example.com with a zone ID
+// derived from the request's token. It returns the lookups made.
+func stubCloudFlareZones(t *testing.T) func() []string {
+	t.Helper()
+	var mutex sync.Mutex
+	var lookups []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mutex.Lock()
+		lookups = append(lookups, token+" "+r.URL.Query().Get("name"))
+		mutex.Unlock()
+
+		var zones []CloudFlareZone
+		if r.URL.Path == "/zones" && r.URL.Query().Get("name") == "example.com" {
+			zones = append(zones, CloudFlareZone{ID: "zone-of-" + token, Name: "example.com"})
+		}
+		result, _ := json.Marshal(zones)
+		json.NewEncoder(w).Encode(CloudFlareResponse{Success: true, Result: result})
+	}))
+	t.Cleanup(server.Close)
+
+	previous := cloudflareAPIBase
+	cloudflareAPIBase = server.URL
+	t.Cleanup(func() { cloudflareAPIBase = previous })
+
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return lookups
+	}
+}
+
+func TestChallengeZone(t *testing.T) {
+	records := []DDNSRecord{
+		{RecordName: "home.other.org", APIToken: "other-token", ZoneID: "other-zone"},
+		{RecordName: "home.example.com", APIToken: "record-token", ZoneID: "record-zone"},
+		{RecordName: "nas.example.com", APIToken: "acme-token", ZoneID: "acme-zone"},
+	}
+
+	tests := []struct {
+		name         string
+		acmeToken    string
+		defaultToken string
+		records      []DDNSRecord
+		domain       string
+		wantToken    string
+		wantZone     string
+		wantLookup   bool
+		wantErr      string
+	}{
+		{"record token and zone", "", "", records, "ddns.example.com", "record-token", "record-zone", false, ""},
+		{"wildcard", "", "", records, "*.example.com", "record-token", "record-zone", false, ""},
+		{"ACME token matches a later record", "acme-token", "", records, "ddns.example.com", "acme-token", "acme-zone", false, ""},
+		{"ACME token of no record", "unused-token", "", records, "ddns.example.com", "unused-token", "zone-of-unused-token", true, ""},
+		{"record without zone ID", "", "", []DDNSRecord{
+			{RecordName: "home.example.com", APIToken: "record-token"},
+			{RecordName: "nas.example.com", APIToken: "record-token", ZoneID: "record-zone"},
+		}, "ddns.example.com", "record-token", "record-zone", false, ""},
+		{"default token", "", "default-token", records[:1], "ddns.example.com", "default-token", "zone-of-default-token", true, ""},
+		{"no token", "", "", records[:1], "ddns.example.com", "", "", false, "no API token for zone example.com"},
+		{"unknown zone", "acme-token", "", records, "ddns.example.net", "", "", true, "zone not found: example.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := stubCloudFlareZones(t)
+			config := newDefaultConfig()
+			config.Records = tt.records
+			config.DefaultAPIToken = tt.defaultToken
+			config.Web.TLS.ACME.APIToken = tt.acmeToken
+			p := &DDNSPilot{config: config, ddns: &DDNSManager{config: config}}
+
+			token, zone, err := p.challengeZone(context.Background(), tt.domain)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.wantToken || zone != tt.wantZone {
+				t.Errorf("got token %q zone %q, want %q %q", token, zone, tt.wantToken, tt.wantZone)
+			}
+			if looked := len(lookups()) > 0; looked != tt.wantLookup {
+				t.Errorf("zone lookups = %v, want lookup %v", lookups(), tt.wantLookup)
+			}
+		})
+	}
+}
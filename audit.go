@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Audit actions
+const (
+	auditLogin          = "login"
+	auditLoginFailed    = "login_failed"
+	auditLoginBlocked   = "login_blocked"
+	auditLogout         = "logout"
+	auditPasswordChange = "password_change"
+	auditRecordAdd      = "record_add"
+	auditRecordEdit     = "record_edit"
+	auditRecordRemove   = "record_remove"
+	auditRecordToggle   = "record_toggle"
+	auditRecordsImport  = "records_import"
+	auditDriftFix       = "drift_fix"
+	auditSettingsChange = "settings_change"
+	auditConfigImport   = "config_import"
+	auditConfigExport   = "config_export"
+)
+
+// cliActor is the actor recorded for changes made from the command line
+const cliActor = "cli"
+
+// auditUsernameMax caps usernames typed at the login form, which anyone
+// can fill with whatever they like
+const auditUsernameMax = 64
+
+// AuditEntry records who did what, from where, and what it changed.
+// Changes are produced by diffConfigValues, so secrets never appear.
+type AuditEntry struct {
+	Time     time.Time      `json:"time"`
+	Actor    string         `json:"actor"`
+	SourceIP string         `json:"source_ip,omitempty"`
+	Action   string         `json:"action"`
+	Target   string         `json:"target,omitempty"`
+	Success  bool           `json:"success"`
+	Details  string         `json:"details,omitempty"`
+	Changes  []ConfigChange `json:"changes,omitempty"`
+}
+
+// AuditFilter selects entries from the audit log
+type AuditFilter struct {
+	Action string
+	Since  time.Time
+	Limit  int
+}
+
+// AuditLog is an append-only JSON lines file of audit entries
+type AuditLog struct {
+	log *jsonLinesLog[AuditEntry]
+}
+
+func NewAuditLog(path string, config *AppConfig) *AuditLog {
+	return &AuditLog{log: &jsonLinesLog[AuditEntry]{
+		name:   "audit",
+		path:   path,
+		timeOf: func(e AuditEntry) time.Time { return e.Time },
+		limits: func() (int, int) { return config.Audit.RetentionDays, config.Audit.MaxEntries },
+	}}
+}
+
+// Record appends an entry; failures are logged, never fatal to the action
+func (a *AuditLog) Record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if err := a.log.Append(entry); err != nil {
+		slog.Warn("Failed to write audit entry", "action", entry.Action, "error", err)
+	}
+}
+
+// Compact applies the retention limit to the audit file
+func (a *AuditLog) Compact() error {
+	return a.log.Compact()
+}
+
+// Query returns matching entries, newest first
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	entries, err := a.log.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			continue
+		}
+		matched = append(matched, entry)
+		if filter.Limit > 0 && len(matched) >= filter.Limit {
+			break
+		}
+	}
+
+	return matched, nil
+}
+
+// writeAuditCSV exports entries as CSV, one change per line
+func writeAuditCSV(w io.Writer, entries []AuditEntry) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"time", "actor", "source_ip", "action", "target", "success", "details", "changes"})
+	for _, entry := range entries {
+		changes := make([]string, len(entry.Changes))
+		for i, change := range entry.Changes {
+			changes[i] = change.String()
+		}
+		writer.Write([]string{
+			entry.Time.Format(time.RFC3339),
+			csvCell(entry.Actor),
+			csvCell(entry.SourceIP),
+			csvCell(entry.Action),
+			csvCell(entry.Target),
+			strconv.FormatBool(entry.Success),
+			csvCell(entry.Details),
+			csvCell(strings.Join(changes, "\n")),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell keeps spreadsheets from running a cell as a formula. Usernames
+// typed at the login form and setting values end up in the export, so a
+// leading = + - @ tab or carriage return is escaped with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// clientIP returns the address a request came from
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// loginActor returns the username submitted to the login form, cut short
+// and with control characters replaced, for the audit log
+func loginActor(r *http.Request) string {
+	username := strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return '?'
+		}
+		return c
+	}, strings.TrimSpace(r.FormValue("username")))
+
+	if runes := []rune(username); len(runes) > auditUsernameMax {
+		username = string(runes[:auditUsernameMax]) + "…"
+	}
+	return username
+}
+
+// sessionActor returns the user of the session behind a request
+func sessionActor(r *http.Request) string {
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		if session, valid := sessionManager.GetSession(cookie.Value); valid {
+			return session.UserID
+		}
+	}
+	return "anonymous"
+}
+
+// snapshotConfig copies the config before a change so it can be diffed
+func (p *DDNSPilot) snapshotConfig() *AppConfig {
+	before, err := cloneConfig(p.config)
+	if err != nil {
+		slog.Warn("Failed to snapshot config for audit", "error", err)
+		return nil
+	}
+	return before
+}
+
+// auditRequest records an action taken through the web interface; if
+// before is set, the entry carries the config diff since then
+func (p *DDNSPilot) auditRequest(r *http.Request, action, target, details string, before *AppConfig) {
+	p.recordAudit(AuditEntry{
+		Actor:    sessionActor(r),
+		SourceIP: clientIP(r),
+		Action:   action,
+		Target:   target,
+		Success:  true,
+		Details:  details,
+	}, before)
+}
+
+// auditCLI records an action taken from the command line
+func (p *DDNSPilot) auditCLI(action, target, details string, before *AppConfig) {
+	p.recordAudit(AuditEntry{
+		Actor:   cliActor,
+		Action:  action,
+		Target:  target,
+		Success: true,
+		Details: details,
+	}, before)
+}
+
+func (p *DDNSPilot) recordAudit(entry AuditEntry, before *AppConfig) {
+	if before != nil {
+		after, err := cloneConfig(p.config)
+		if err == nil {
+			entry.Changes, err = diffConfigValues(before, after)
+		}
+		if err != nil {
+			slog.Warn("Failed to diff config for audit", "error", err)
+		}
+	}
+	p.audit.Record(entry)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteAuditCSVEscapesFormulas(t *testing.T) {
+	when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []AuditEntry{
+		{Time: when, Actor: "=HYPERLINK(\"http://evil.example\",\"x\")", SourceIP: "@SUM(A1)", Action: "login", Target: "+1", Details: "-2+3"},
+		{Time: when, Actor: "\tadmin", SourceIP: "192.0.2.1", Action: "login", Target: "\rhome", Success: true, Details: "a=b"},
+		{Time: when, Actor: "admin", Action: "settings", Success: true, Changes: []ConfigChange{{Path: "records[0]", Action: "added", After: "home.example.com"}}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAuditCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"time", "actor", "source_ip", "action", "target", "success", "details", "changes"},
+		{"2026-01-02T03:04:05Z", "'=HYPERLINK(\"http://evil.example\",\"x\")", "'@SUM(A1)", "login", "'+1", "false", "'-2+3", ""},
+		{"2026-01-02T03:04:05Z", "'\tadmin", "192.0.2.1", "login", "'\rhome", "true", "a=b", ""},
+		{"2026-01-02T03:04:05Z", "admin", "", "settings", "", "true", "", "'" + entries[2].Changes[0].String()},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows =\n%q\nwant\n%q", rows, want)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// configBundleVersion is the version of the export format itself
+const configBundleVersion = 1
+
+// redactedValue replaces secrets in redacted exports
+const redactedValue = "REDACTED"
+
+// Import strategies
+const (
+	importStrategyReplace = "replace"
+	importStrategyMerge   = "merge"
+)
+
+// ConfigBundle is the portable config export format
+type ConfigBundle struct {
+	BundleVersion int               `json:"bundle_version"`
+	ExportedAt    string            `json:"exported_at"`
+	Redacted      bool              `json:"redacted"`
+	Config        json.RawMessage   `json:"config,omitempty"`
+	Encrypted     *EncryptedPayload `json:"encrypted,omitempty"`
+}
+
+// EncryptedPayload holds a passphrase-encrypted config (scrypt + AES-256-GCM)
+type EncryptedPayload struct {
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// ExportOptions controls how a config bundle is produced
+type ExportOptions struct {
+	Format     string // "json" or "yaml"
+	Redact     bool
+	Passphrase string
+}
+
+// ImportPlan is the result of applying a bundle to the current config,
+// computed without touching the running configuration
+type ImportPlan struct {
+	Strategy string         `json:"strategy"`
+	Changes  []ConfigChange `json:"changes"`
+	Warnings []string       `json:"warnings,omitempty"`
+	Result   *AppConfig     `json:"-"`
+}
+
+// exportConfig produces a versioned config bundle
+func exportConfig(config *AppConfig, opts ExportOptions) ([]byte, error) {
+	// Values from the environment belong to this host, not the bundle
+	configJSON, err := config.persistedJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	if opts.Redact {
+		node, err := jsonToNode(configJSON)
+		if err != nil {
+			return nil, err
+		}
+		redactNode(node, "")
+		if configJSON, err = nodeToJSON(node); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle := ConfigBundle{
+		BundleVersion: configBundleVersion,
+		ExportedAt:    time.Now().Format(time.RFC3339),
+		Redacted:      opts.Redact,
+	}
+
+	if opts.Passphrase != "" {
+		if bundle.Encrypted, err = encryptPayload(configJSON, opts.Passphrase); err != nil {
+			return nil, err
+		}
+	} else {
+		bundle.Config = configJSON
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bundle: %v", err)
+	}
+
+	switch opts.Format {
+	case "", "json":
+		return data, nil
+	case "yaml", "yml":
+		node, err := jsonToNode(data)
+		if err != nil {
+			return nil, err
+		}
+		return marshalYAMLNode(node)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", opts.Format)
+	}
+}
+
+// parseConfigBundle reads a JSON or YAML bundle, decrypting it if needed
+func parseConfigBundle(data []byte, passphrase string) (*AppConfig, *ConfigBundle, error) {
+	jsonData := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(jsonData, []byte("{")) {
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse bundle: %v", err)
+		}
+		var err error
+		if jsonData, err = nodeToJSON(&node); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse bundle: %v", err)
+		}
+	}
+
+	var bundle ConfigBundle
+	if err := json.Unmarshal(jsonData, &bundle); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse bundle: %v", err)
+	}
+
+	if bundle.BundleVersion == 0 {
+		return nil, nil, fmt.Errorf("not a DDNS Pilot config bundle (missing bundle_version)")
+	}
+	if bundle.BundleVersion > configBundleVersion {
+		return nil, nil, fmt.Errorf("bundle version %d is newer than supported version %d", bundle.BundleVersion, configBundleVersion)
+	}
+
+	configJSON := []byte(bundle.Config)
+	if bundle.Encrypted != nil {
+		if passphrase == "" {
+			return nil, nil, fmt.Errorf("bundle is encrypted - a passphrase is required")
+		}
+		var err error
+		if configJSON, err = decryptPayload(bundle.Encrypted, passphrase); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(configJSON) == 0 {
+		return nil, nil, fmt.Errorf("bundle contains no config")
+	}
+
+	// Bundles from older releases carry older config schemas
+	config, _, err := decodeConfigDocument(configJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bundled config: %v", err)
+	}
+
+	return config, &bundle, nil
+}
+
+// planConfigImport merges or replaces the current config with an imported
+// one. Redacted secrets are taken from the matching current entries.
+func planConfigImport(current, imported *AppConfig, strategy string) (*ImportPlan, error) {
+	plan := &ImportPlan{Strategy: strategy}
+
+	// Plan against the file's values; environment overrides are re-applied
+	// on top of the result
+	overrides := current.envOverrides
+	current, err := cloneConfig(current)
+	if err != nil {
+		return nil, err
+	}
+
+	restored, warnings, err := restoreRedactedSecrets(imported, current)
+	if err != nil {
+		return nil, err
+	}
+	plan.Warnings = warnings
+
+	switch strategy {
+	case importStrategyReplace:
+		plan.Result = restored
+	case importStrategyMerge:
+		merged, err := cloneConfig(current)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range restored.Records {
+			if existing, err := merged.GetRecord(record.RecordName, record.Type()); err == nil {
+				*existing = record
+			} else {
+				merged.Records = append(merged.Records, record)
+			}
+		}
+		plan.Result = merged
+	default:
+		return nil, fmt.Errorf("unknown import strategy %q (use %s or %s)", strategy, importStrategyReplace, importStrategyMerge)
+	}
+
+	if errs := validateConfig(plan.Result); len(errs) > 0 {
+		return nil, fmt.Errorf("import would produce an invalid config: %v", errs)
+	}
+
+	if plan.Changes, err = diffConfigValues(current, plan.Result); err != nil {
+		return nil, err
+	}
+
+	for _, override := range overrides {
+		path := strings.Join(override.Path, ".")
+		for _, change := range plan.Changes {
+			if change.Path == path || strings.HasPrefix(change.Path, path+".") || strings.HasPrefix(change.Path, path+"[") {
+				plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s is set by %s - the imported value is saved but the environment value stays in effect", path, override.Variable))
+				break
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// cloneConfig returns a deep copy of a config as it is stored in the file
+func cloneConfig(config *AppConfig) (*AppConfig, error) {
+	data, err := config.persistedJSON()
+	if err != nil {
+		return nil, err
+	}
+	clone := &AppConfig{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// restoreRedactedSecrets returns a copy of imported where every redacted
+// secret is replaced by the value at the same place in current
+func restoreRedactedSecrets(imported, current *AppConfig) (*AppConfig, []string, error) {
+	importedTree, err := toGenericTree(imported)
+	if err != nil {
+		return nil, nil, err
+	}
+	currentTree, err := toGenericTree(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	restoreRedacted("", "", importedTree, currentTree, &warnings)
+
+	data, err := json.Marshal(importedTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	restored := newDefaultConfig()
+	if err := json.Unmarshal(data, restored); err != nil {
+		return nil, nil, err
+	}
+
+	return restored, warnings, nil
+}
+
+func restoreRedacted(path, parent string, imported, current interface{}, warnings *[]string) {
+	switch v := imported.(type) {
+	case map[string]interface{}:
+		currentMap, _ := current.(map[string]interface{})
+		for key, value := range v {
+			if s, ok := value.(string); ok && s == redactedValue && isSecretField(parent, key) {
+				if currentValue, ok := currentMap[key].(string); ok && currentValue != redactedValue {
+					v[key] = currentValue
+				} else {
+					v[key] = ""
+					*warnings = append(*warnings, fmt.Sprintf("%s is redacted and has no current value to keep", joinConfigPath(path, key)))
+				}
+				continue
+			}
+			restoreRedacted(joinConfigPath(path, key), key, value, currentMap[key], warnings)
+		}
+	case []interface{}:
+		currentList, _ := current.([]interface{})
+		for i, item := range v {
+			if key, ok := listItemKey(item); ok {
+				restoreRedacted(fmt.Sprintf("%s[%s]", path, key), parent, item, findListItem(currentList, key), warnings)
+			} else {
+				restoreRedacted(fmt.Sprintf("%s[%d]", path, i), parent, item, nil, warnings)
+			}
+		}
+	}
+}
+
+// deriveBundleKey stretches a passphrase into an AES-256 key
+func deriveBundleKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func encryptPayload(plaintext []byte, passphrase string) (*EncryptedPayload, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := deriveBundleKey(passphrase, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+
+	gcm, err := newBundleCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &EncryptedPayload{
+		KDF:        "scrypt",
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}, nil
+}
+
+func decryptPayload(payload *EncryptedPayload, passphrase string) ([]byte, error) {
+	if !strings.EqualFold(payload.KDF, "scrypt") {
+		return nil, fmt.Errorf("unsupported key derivation: %s", payload.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(payload.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %v", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(payload.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(payload.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	key, err := deriveBundleKey(passphrase, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+
+	gcm, err := newBundleCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bundle - wrong passphrase?")
+	}
+	return plaintext, nil
+}
+
+func newBundleCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testBundleConfig returns a valid config with secrets in a record and the
+// web password
+func testBundleConfig() *AppConfig {
+	config := newDefaultConfig()
+	config.Web.Password = "$2a$04$jnyWmWC1ZgsFAK3uOtNegOjEOUXk2bGIFXFfSW.TpyInje7l0yRBa"
+	config.Records = []DDNSRecord{{
+		RecordName: "home.example.com",
+		APIToken:   "cf-token-secret",
+		ZoneID:     "zone1",
+		RecordID:   "rec1",
+		Enabled:    true,
+		Notes:      "router",
+	}}
+	return config
+}
+
+func mustPersistedJSON(t *testing.T, config *AppConfig) string {
+	t.Helper()
+	data, err := config.persistedJSON()
+	if err != nil {
+		t.Fatalf("persistedJSON: %v", err)
+	}
+	return string(data)
+}
+
+func TestConfigBundleRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		passphrase string
+	}{
+		{"json", "json", ""},
+		{"yaml", "yaml", ""},
+		{"encrypted json", "json", "correct horse"},
+		{"encrypted yaml", "yaml", "correct horse"},
+	}
+
+	config := testBundleConfig()
+	want := mustPersistedJSON(t, config)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := exportConfig(config, ExportOptions{Format: tt.format, Passphrase: tt.passphrase})
+			if err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			if tt.passphrase != "" && bytes.Contains(data, []byte("cf-token-secret")) {
+				t.Fatal("encrypted bundle contains the API token in plain text")
+			}
+
+			imported, bundle, err := parseConfigBundle(data, tt.passphrase)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if bundle.BundleVersion != configBundleVersion || bundle.Redacted {
+				t.Errorf("bundle version %d, redacted %v", bundle.BundleVersion, bundle.Redacted)
+			}
+			if got := mustPersistedJSON(t, imported); got != want {
+				t.Errorf("round trip changed the config\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConfigBundleRedacted(t *testing.T) {
+	current := testBundleConfig()
+	current.Notifiers = []NotifierConfig{{
+		Name:    "hook",
+		Type:    notifierWebhook,
+		Enabled: true,
+		URL:     "https://hooks.example.com/ddns?token=url-token-secret",
+		Headers: map[string]string{"X-Api-Key": "header-key-secret"},
+	}}
+	secrets := []string{"cf-token-secret", "$2a$", "url-token-secret", "header-key-secret"}
+
+	data, err := exportConfig(current, ExportOptions{Redact: true})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Fatalf("redacted bundle contains %q: %s", secret, data)
+		}
+	}
+
+	imported, _, err := parseConfigBundle(data, "")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	plan, err := planConfigImport(current, imported, importStrategyReplace)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if got := plan.Result.Records[0].APIToken; got != "cf-token-secret" {
+		t.Errorf("API token after import = %q, want the current one", got)
+	}
+	if got := plan.Result.Web.Password; got != current.Web.Password {
+		t.Errorf("password after import = %q, want the current one", got)
+	}
+	if got := plan.Result.Notifiers[0]; got.URL != current.Notifiers[0].URL || got.Headers["X-Api-Key"] != "header-key-secret" {
+		t.Errorf("webhook after import = %q %v, want the current URL and headers", got.URL, got.Headers)
+	}
+	if len(plan.Changes) != 0 {
+		t.Errorf("importing our own export changes %v", plan.Changes)
+	}
+
+	// The log redaction learns the same values
+	tree, err := toGenericTree(current)
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	var found []string
+	collectSecrets("", tree, &found)
+	for _, secret := range []string{"cf-token-secret", current.Notifiers[0].URL, "header-key-secret"} {
+		if !slices.Contains(found, secret) {
+			t.Errorf("log redaction misses %q (found %q)", secret, found)
+		}
+	}
+
+	// Change lists, as in the audit log, hide the same values
+	changed := testBundleConfig()
+	changed.Notifiers = []NotifierConfig{{
+		Name:    "hook",
+		Type:    notifierWebhook,
+		Enabled: true,
+		URL:     "https://hooks.example.com/ddns?token=new-url-secret",
+		Headers: map[string]string{"X-Api-Key": "new-header-secret"},
+	}}
+	for _, config := range []*AppConfig{changed, newDefaultConfig()} {
+		changes, err := diffConfigValues(current, config)
+		if err != nil {
+			t.Fatalf("diff: %v", err)
+		}
+		for _, change := range changes {
+			for _, secret := range append(secrets, "new-url-secret", "new-header-secret") {
+				if strings.Contains(change.String(), secret) {
+					t.Errorf("change %q shows %q", change, secret)
+				}
+			}
+		}
+	}
+}
+
+func TestConfigBundleDecryptErrors(t *testing.T) {
+	data, err := exportConfig(testBundleConfig(), ExportOptions{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	// tamper edits the encrypted payload of the bundle
+	tamper := func(edit func(p *EncryptedPayload)) []byte {
+		var bundle ConfigBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		edit(bundle.Encrypted)
+		out, err := json.Marshal(bundle)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return out
+	}
+	// flipByte changes byte i of a base64 value; negative i counts from
+	// the end
+	flipByte := func(encoded string, i int) string {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if i < 0 {
+			i += len(raw)
+		}
+		raw[i] ^= 0x01
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name       string
+		bundle     []byte
+		passphrase string
+		want       string
+	}{
+		{"wrong passphrase", data, "battery staple", "wrong passphrase"},
+		{"missing passphrase", data, "", "a passphrase is required"},
+		{
+			name:       "tampered ciphertext",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Ciphertext = flipByte(p.Ciphertext, 10) }),
+			passphrase: "correct horse",
+			want:       "failed to decrypt bundle",
+		},
+		{
+			name:       "tampered authentication tag",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Ciphertext = flipByte(p.Ciphertext, -1) }),
+			passphrase: "correct horse",
+			want:       "failed to decrypt bundle",
+		},
+		{
+			name:       "truncated ciphertext",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Ciphertext = p.Ciphertext[:8] }),
+			passphrase: "correct horse",
+			want:       "failed to decrypt bundle",
+		},
+		{
+			name:       "tampered salt",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Salt = flipByte(p.Salt, 0) }),
+			passphrase: "correct horse",
+			want:       "failed to decrypt bundle",
+		},
+		{
+			name:       "tampered nonce",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Nonce = flipByte(p.Nonce, 0) }),
+			passphrase: "correct horse",
+			want:       "failed to decrypt bundle",
+		},
+		{
+			name:       "short nonce",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Nonce = base64.StdEncoding.EncodeToString([]byte("short")) }),
+			passphrase: "correct horse",
+			want:       "invalid nonce length",
+		},
+		{
+			name:       "ciphertext not base64",
+			bundle:     tamper(func(p *EncryptedPayload) { p.Ciphertext = "not base64!" }),
+			passphrase: "correct horse",
+			want:       "invalid ciphertext",
+		},
+		{
+			name:       "unknown key derivation",
+			bundle:     tamper(func(p *EncryptedPayload) { p.KDF = "pbkdf2" }),
+			passphrase: "correct horse",
+			want:       "unsupported key derivation: pbkdf2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, err := parseConfigBundle(tt.bundle, tt.passphrase)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if config != nil {
+				t.Errorf("got a config along with the error")
+			}
+		})
+	}
+}
+
+func TestConfigBundleVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		bundle string
+		want   string
+	}{
+		{"not a bundle", `{"records": []}`, "missing bundle_version"},
+		{"newer version", `{"bundle_version": 99, "config": {}}`, "newer than supported"},
+		{"empty", `{"bundle_version": 1}`, "bundle contains no config"},
+		{"not JSON or YAML", "{{", "failed to parse bundle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseConfigBundle([]byte(tt.bundle), ""); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
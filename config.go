@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,12 +22,50 @@ type DDNSRecord struct {
 	Proxied    bool   `json:"proxied"`
 	ZoneID     string `json:"zone_id"`
 	RecordID   string `json:"record_id"`
+	RecordType string `json:"record_type,omitempty"` // "A" (default) or "AAAA"
 	// Additional fields for enhanced functionality
 	Enabled     bool   `json:"enabled"`
 	CreatedAt   string `json:"created_at"`
 	LastUpdated string `json:"last_updated"`
 	LastIP      string `json:"last_ip"`
 	Notes       string `json:"notes"`
+
+	// Commands run around this record's IP changes, after the global ones
+	Hooks *HooksConfig `json:"hooks,omitempty"`
+}
+
+// Type returns the DNS record type, defaulting to A for older configs
+func (r DDNSRecord) Type() string {
+	return recordTypeOrDefault(r.RecordType)
+}
+
+// recordTypeOrDefault treats a missing record type as A
+func recordTypeOrDefault(recordType string) string {
+	if recordType == "" {
+		return defaultRecordType
+	}
+	return recordType
+}
+
+// Is reports whether this is the record with the given name and, unless
+// recordType is empty, type. A name can have both an A and an AAAA record.
+func (r DDNSRecord) Is(recordName, recordType string) bool {
+	return r.RecordName == recordName && (recordType == "" || r.Type() == recordType)
+}
+
+// Key identifies the record where its name alone is ambiguous, such as in
+// MQTT topics and dashboard timelines
+func (r DDNSRecord) Key() string {
+	return recordKey(r.RecordName, r.Type())
+}
+
+// recordKey is the name for A records, so existing keys stay the same, and
+// name:TYPE for the others
+func recordKey(recordName, recordType string) string {
+	if recordTypeOrDefault(recordType) == defaultRecordType {
+		return recordName
+	}
+	return recordName + ":" + recordType
 }
 
 // WebConfig represents web interface configuration
@@ -36,10 +75,34 @@ type WebConfig struct {
 	SessionTimeout         int    `json:"session_timeout"`          // Minutes
 	DefaultPasswordChanged bool   `json:"default_password_changed"` // Track if admin/admin was changed
 	SecurityAcknowledged   bool   `json:"security_acknowledged"`    // Track if user acknowledged security warnings
+
+	Listen     []string `json:"listen,omitempty"` // Addresses such as 127.0.0.1:8082 or unix:/run/ddns-pilot.sock; overrides port
+	SocketMode string   `json:"socket_mode"`      // Octal permissions for Unix sockets
+
+	TrustedProxies []string `json:"trusted_proxies,omitempty"` // CIDRs whose forwarding headers name the real client
+	BasePath       string   `json:"base_path,omitempty"`       // Path prefix when served below / by a proxy, such as /ddns
+
+	TLS WebTLSConfig `json:"tls"`
+}
+
+// HistoryConfig controls how long update history is kept
+type HistoryConfig struct {
+	File          string `json:"file,omitempty"` // Defaults to <config>.history.jsonl
+	RetentionDays int    `json:"retention_days"` // 0 keeps entries forever
+	MaxEntries    int    `json:"max_entries"`    // 0 means no limit
+}
+
+// AuditConfig controls where the audit log is kept and for how long
+type AuditConfig struct {
+	File          string `json:"file,omitempty"` // Defaults to <config>.audit.jsonl
+	RetentionDays int    `json:"retention_days"` // 0 keeps entries forever
+	MaxEntries    int    `json:"max_entries"`    // 0 means no limit
 }
 
 // AppConfig represents the complete application configuration
 type AppConfig struct {
+	SchemaVersion int `json:"schema_version"`
+
 	Records []DDNSRecord `json:"records"`
 	Web     WebConfig    `json:"web"`
 
@@ -49,6 +112,25 @@ type AppConfig struct {
 
 	// Default CloudFlare API Token for new records
 	DefaultAPIToken string `json:"default_api_token"`
+
+	// Where to send alerts about IP changes, failures and drift
+	Notifiers []NotifierConfig `json:"notifiers"`
+
+	// Commands run around every record's IP changes
+	Hooks HooksConfig `json:"hooks"`
+
+	// Publish state to an MQTT broker, e.g. for Home Assistant
+	MQTT MQTTConfig `json:"mqtt"`
+
+	Metrics MetricsConfig `json:"metrics"`
+	Health  HealthConfig  `json:"health"`
+	Logs    LogsConfig    `json:"logs"`
+
+	History HistoryConfig `json:"history"`
+	Audit   AuditConfig   `json:"audit"`
+
+	// Settings taken from DDNS_PILOT_* variables; see env.go
+	envOverrides []envOverride
 }
 
 // Session represents an active user session
@@ -57,6 +139,7 @@ type Session struct {
 	UserID    string    `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+	CSRFToken string    `json:"-"` // Carried by every form and state-changing request
 }
 
 // SessionManager handles user sessions
@@ -88,44 +171,139 @@ var rateLimiter = &RateLimiter{
 
 const defaultConfigPath = "ddns-pilot.json"
 
-func loadConfig() (*AppConfig, error) {
-	config := &AppConfig{
-		Records: []DDNSRecord{},
+// configPath is the config file in use; its extension selects the format
+var configPath = defaultConfigPath
+
+// saveMutex serializes writes of the config file, which both the web
+// interface and update runs save
+var saveMutex sync.Mutex
+
+// resolveConfigPath picks the config file: an explicit path wins, otherwise
+// the first existing ddns-pilot.{json,yaml,yml,toml}, falling back to JSON
+func resolveConfigPath(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	for _, candidate := range []string{"ddns-pilot.json", "ddns-pilot.yaml", "ddns-pilot.yml", "ddns-pilot.toml"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return defaultConfigPath
+}
+
+// newDefaultConfig returns the configuration used when no config file exists
+func newDefaultConfig() *AppConfig {
+	return &AppConfig{
+		SchemaVersion: currentSchemaVersion,
+		Records:       []DDNSRecord{},
 		Web: WebConfig{
 			Port:           8082,
 			Password:       "admin",
 			SessionTimeout: 60,
+			SocketMode:     "0660",
+			TLS: WebTLSConfig{
+				HSTSMaxAge: 31536000, // One year
+				ACME: ACMEConfig{
+					DirectoryURL:    letsEncryptDirectory,
+					RenewBeforeDays: 30,
+					PropagationWait: 20,
+				},
+			},
 		},
 		UpdateInterval: 5, // 5 minutes default
 		AutoUpdate:     false,
+		Notifiers:      []NotifierConfig{},
+		History: HistoryConfig{
+			RetentionDays: 90,
+			MaxEntries:    10000,
+		},
+		Audit: AuditConfig{
+			RetentionDays: 365,
+			MaxEntries:    10000,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Health: HealthConfig{
+			CheckIPSource:   true,
+			IPCheckInterval: 300,
+		},
+		Logs: LogsConfig{
+			BufferSize: defaultLogBufferSize,
+			MaxSizeMB:  10,
+			MaxFiles:   5,
+		},
+		MQTT: MQTTConfig{
+			ClientID:        "ddns-pilot",
+			TopicPrefix:     "ddns-pilot",
+			Discovery:       true,
+			DiscoveryPrefix: "homeassistant",
+			KeepAlive:       60,
+		},
+	}
+}
+
+// HistoryPath returns the update history file
+func (c *AppConfig) HistoryPath() string {
+	if c.History.File != "" {
+		return c.History.File
+	}
+	return historyPathFor(configPath)
+}
+
+// AuditPath returns the audit log file
+func (c *AppConfig) AuditPath() string {
+	if c.Audit.File != "" {
+		return c.Audit.File
+	}
+	return sidecarPath(configPath, ".audit.jsonl")
+}
+
+func loadConfig() (*AppConfig, error) {
+	config := newDefaultConfig()
+
+	if _, err := configFormatFor(configPath); err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(defaultConfigPath); os.IsNotExist(err) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// Hash the default password before returning
 		if hashedPassword, err := HashPassword(config.Web.Password); err == nil {
 			config.Web.Password = hashedPassword
 		}
+		if err := config.applyEnvOverrides(); err != nil {
+			return nil, fmt.Errorf("invalid environment override: %v", err)
+		}
+		logSecrets.Learn(config)
 		return config, nil
 	}
 
-	data, err := os.ReadFile(defaultConfigPath)
+	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
-	if err := json.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	jsonData, err := configDocumentToJSON(configPath, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", configPath, err)
 	}
 
-	// Migrate old configs and set defaults
-	if config.Web.Port == 0 {
-		config.Web.Port = 8082
-	}
-	if config.Web.SessionTimeout == 0 {
-		config.Web.SessionTimeout = 60
+	config, fromVersion, err := decodeConfigDocument(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", configPath, err)
 	}
-	if config.UpdateInterval == 0 {
-		config.UpdateInterval = 5
+
+	// Persist migrations, keeping the original file as a backup
+	if fromVersion < currentSchemaVersion {
+		backupPath, err := backupConfigFile(configPath, data, fromVersion)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Config upgraded", "from_schema", fromVersion, "to_schema", currentSchemaVersion, "backup", backupPath)
+		if err := config.save(); err != nil {
+			return nil, fmt.Errorf("failed to save migrated config: %v", err)
+		}
 	}
 
 	// SECURITY: Migrate plaintext passwords to hashed passwords
@@ -133,31 +311,71 @@ func loadConfig() (*AppConfig, error) {
 		if hashedPassword, err := HashPassword(config.Web.Password); err == nil {
 			config.Web.Password = hashedPassword
 			if saveErr := config.save(); saveErr != nil {
-				fmt.Printf("Warning: Failed to save hashed password to config: %v\n", saveErr)
+				slog.Warn("Failed to save hashed password to config", "error", saveErr)
 			}
 		} else {
-			fmt.Printf("Warning: Failed to hash password: %v\n", err)
+			slog.Warn("Failed to hash password", "error", err)
 		}
 	}
 
+	if err := config.applyEnvOverrides(); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %v", err)
+	}
+	for _, override := range config.envOverrides {
+		slog.Info("Setting overridden from the environment", "setting", strings.Join(override.Path, "."), "variable", override.Variable)
+	}
+	logSecrets.Learn(config)
+
 	return config, nil
 }
 
 func (c *AppConfig) save() error {
-	data, err := json.MarshalIndent(c, "", "  ")
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	logSecrets.Learn(c)
+
+	data, err := c.persistedJSON()
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
-	if err := os.WriteFile(defaultConfigPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write config file: %v", err)
+	// The current file is the template that keeps YAML comments in place
+	previous, _ := os.ReadFile(configPath)
+
+	data, err = encodeConfigDocument(configPath, data, previous)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
 	}
 
-	return nil
+	// Write and rename, so a crash mid-write can't leave a truncated file
+	return writeFileAtomic(configPath, data, 0600)
+}
+
+// persistedJSON is the config as it belongs in the file: settings overridden
+// from the environment keep the file's own values
+func (c *AppConfig) persistedJSON() ([]byte, error) {
+	if len(c.envOverrides) == 0 {
+		return json.Marshal(c)
+	}
+
+	tree, err := toGenericTree(c)
+	if err != nil {
+		return nil, err
+	}
+	c.restoreEnvOriginals(tree.(map[string]interface{}))
+	return json.Marshal(tree)
+}
+
+// replaceWith swaps in a new config (e.g. from an import) while keeping
+// environment overrides in force
+func (c *AppConfig) replaceWith(other *AppConfig) error {
+	*c = *other
+	return c.applyEnvOverrides()
 }
 
 func ensureConfigDir() error {
-	dir := filepath.Dir(defaultConfigPath)
+	dir := filepath.Dir(configPath)
 	return os.MkdirAll(dir, 0755)
 }
 
@@ -174,7 +392,9 @@ func (rl *RateLimiter) IsBlocked(ip string) bool {
 	return time.Now().Before(attempt.BlockedUntil)
 }
 
-func (rl *RateLimiter) RecordFailedAttempt(ip string) {
+// RecordFailedAttempt counts a failed login and reports whether it caused
+// the address to be blocked
+func (rl *RateLimiter) RecordFailedAttempt(ip string) bool {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 
@@ -190,7 +410,9 @@ func (rl *RateLimiter) RecordFailedAttempt(ip string) {
 	// Block for 15 minutes after 5 failed attempts
 	if attempt.FailedAttempts >= 5 {
 		attempt.BlockedUntil = time.Now().Add(15 * time.Minute)
+		return true
 	}
+	return false
 }
 
 func (rl *RateLimiter) RecordSuccessfulLogin(ip string) {
@@ -228,6 +450,10 @@ func (sm *SessionManager) CreateSession(userID string, timeoutMinutes int) (*Ses
 	if err != nil {
 		return nil, err
 	}
+	csrfToken, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
 	session := &Session{
@@ -235,6 +461,7 @@ func (sm *SessionManager) CreateSession(userID string, timeoutMinutes int) (*Ses
 		UserID:    userID,
 		CreatedAt: now,
 		ExpiresAt: now.Add(time.Duration(timeoutMinutes) * time.Minute),
+		CSRFToken: csrfToken,
 	}
 
 	sm.sessions[sessionID] = session
@@ -296,9 +523,9 @@ func (c *AppConfig) AddRecord(record DDNSRecord) {
 	c.Records = append(c.Records, record)
 }
 
-func (c *AppConfig) RemoveRecord(recordName string) error {
+func (c *AppConfig) RemoveRecord(recordName, recordType string) error {
 	for i, record := range c.Records {
-		if record.RecordName == recordName {
+		if record.Is(recordName, recordType) {
 			c.Records = append(c.Records[:i], c.Records[i+1:]...)
 			return nil
 		}
@@ -306,9 +533,9 @@ func (c *AppConfig) RemoveRecord(recordName string) error {
 	return fmt.Errorf("record not found: %s", recordName)
 }
 
-func (c *AppConfig) UpdateRecord(recordName string, updatedRecord DDNSRecord) error {
+func (c *AppConfig) UpdateRecord(recordName, recordType string, updatedRecord DDNSRecord) error {
 	for i, record := range c.Records {
-		if record.RecordName == recordName {
+		if record.Is(recordName, recordType) {
 			// Preserve creation time
 			updatedRecord.CreatedAt = record.CreatedAt
 			updatedRecord.LastUpdated = time.Now().Format(time.RFC3339)
@@ -319,9 +546,11 @@ func (c *AppConfig) UpdateRecord(recordName string, updatedRecord DDNSRecord) er
 	return fmt.Errorf("record not found: %s", recordName)
 }
 
-func (c *AppConfig) GetRecord(recordName string) (*DDNSRecord, error) {
+// GetRecord finds a record by name and type; an empty type matches the
+// first record with the name
+func (c *AppConfig) GetRecord(recordName, recordType string) (*DDNSRecord, error) {
 	for i, record := range c.Records {
-		if record.RecordName == recordName {
+		if record.Is(recordName, recordType) {
 			return &c.Records[i], nil
 		}
 	}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// csrfField is the hidden form field carrying the token
+	csrfField = "csrf_token"
+	// csrfHeader carries the token for scripts and fetch calls, and is set
+	// on responses so scripts can pick it up
+	csrfHeader = "X-CSRF-Token"
+	// csrfCookie holds the token for forms shown before login
+	csrfCookie = "csrf_token"
+)
+
+// newCookie returns a cookie with the flags every cookie the web interface
+// sets shares: HttpOnly, SameSite=Strict, Secure over HTTPS and scoped to
+// web.base_path. A negative maxAge deletes the cookie.
+func newCookie(r *http.Request, config *AppConfig, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     config.URL("/"),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// expectedCSRFToken returns the token a request has to carry: its session's,
+// or before login the one in the CSRF cookie. "" when there is neither.
+func expectedCSRFToken(r *http.Request) string {
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		if session, valid := sessionManager.GetSession(cookie.Value); valid {
+			return session.CSRFToken
+		}
+	}
+	if cookie, err := r.Cookie(csrfCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// csrfToken returns the token for the forms of a page, setting the CSRF
+// cookie first if the visitor isn't logged in and has none yet
+func csrfToken(w http.ResponseWriter, r *http.Request, config *AppConfig) string {
+	token := expectedCSRFToken(r)
+	if token == "" {
+		var err error
+		if token, err = generateSessionID(); err != nil {
+			slog.Error("Failed to generate CSRF token", "error", err)
+			return ""
+		}
+		http.SetCookie(w, newCookie(r, config, csrfCookie, token, 0))
+	}
+	w.Header().Set(csrfHeader, token)
+	return token
+}
+
+// withCSRF rejects POSTs and other state-changing requests that don't carry
+// the token, in the csrf_token form field or the X-CSRF-Token header. A page
+// on another site can make the browser send our cookies, but can't read the
+// token.
+func (p *DDNSPilot) withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The header is checked first so API bodies such as config
+		// bundles aren't parsed as forms
+		submitted := r.Header.Get(csrfHeader)
+		if submitted == "" {
+			submitted = r.PostFormValue(csrfField)
+		}
+		expected := expectedCSRFToken(r)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) != 1 {
+			slog.Warn("Rejected request without a valid CSRF token", "method", r.Method, "path", r.URL.Path, "client", clientIP(r))
+			http.Error(w, "Invalid or missing CSRF token. Reload the page and try again.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
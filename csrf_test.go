@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplateCSRFToken(t *testing.T) {
+	initTemplates(func(path string) string { return path })
+	p := &DDNSPilot{config: newDefaultConfig()}
+
+	// Visitors without a session each get their own token
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		data := struct {
+			page
+			Error                string
+			IsBlocked            bool
+			UsingDefaultPassword bool
+		}{}
+		p.renderTemplate(w, httptest.NewRequest("GET", "/login", nil), "login.html", &data)
+
+		token := w.Header().Get(csrfHeader)
+		if token == "" {
+			t.Fatal("no CSRF token header")
+		}
+		if !strings.Contains(w.Body.String(), `name="csrf_token" value="`+token+`"`) {
+			t.Errorf("form does not carry the token %s", token)
+		}
+		tokens = append(tokens, token)
+	}
+	if tokens[0] == tokens[1] {
+		t.Errorf("two visitors got the same token %s", tokens[0])
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	config := newDefaultConfig()
+	p := &DDNSPilot{config: config, audit: NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"), config)}
+	handler := p.withCSRF(http.HandlerFunc(p.handleLogout))
+
+	session, err := sessionManager.CreateSession("admin", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, token string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		if token != "" {
+			form.Set(csrfField, token)
+		}
+		r := httptest.NewRequest(method, "/logout", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: "session_id", Value: session.ID})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("GET", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if w := request("POST", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("POST with a wrong token: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, valid := sessionManager.GetSession(session.ID); !valid {
+		t.Fatal("session ended by a rejected logout")
+	}
+
+	if w := request("POST", session.CSRFToken); w.Code != http.StatusSeeOther {
+		t.Errorf("POST: status %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if _, valid := sessionManager.GetSession(session.ID); valid {
+		t.Error("session still valid after logout")
+	}
+}
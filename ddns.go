@@ -2,15 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"os/exec"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
+// cloudflareAPIBase is a variable so tests can point it at a stub
+var cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
+
+// Values written to CloudFlare for every managed record
+const (
+	defaultRecordType = "A"
+	defaultRecordTTL  = 300
+)
+
 // CloudFlareAPI represents API response structures
 type CloudFlareZone struct {
 	ID   string `json:"id"`
@@ -18,7 +30,7 @@ type CloudFlareZone struct {
 }
 
 type CloudFlareRecord struct {
-	ID      string `json:"id"`
+	ID      string `json:"id,omitempty"`
 	Name    string `json:"name"`
 	Type    string `json:"type"`
 	Content string `json:"content"`
@@ -27,9 +39,18 @@ type CloudFlareRecord struct {
 }
 
 type CloudFlareResponse struct {
-	Success bool              `json:"success"`
-	Errors  []CloudFlareError `json:"errors"`
-	Result  json.RawMessage   `json:"result"`
+	Success    bool                  `json:"success"`
+	Errors     []CloudFlareError     `json:"errors"`
+	Result     json.RawMessage       `json:"result"`
+	ResultInfo *CloudFlareResultInfo `json:"result_info,omitempty"`
+
+	StatusCode int `json:"-"`
+}
+
+type CloudFlareResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
 }
 
 type CloudFlareError struct {
@@ -40,25 +61,136 @@ type CloudFlareError struct {
 // UpdateResult represents the result of a DNS update
 type UpdateResult struct {
 	RecordName string
+	RecordType string
 	Success    bool
+	Changed    bool // The record at CloudFlare now points to a different IP
 	OldIP      string
 	NewIP      string
 	Message    string
 	UpdatedAt  time.Time
+	Hooks      []HookRun // Hooks run for this update, if any
 }
 
 // DDNSManager handles DDNS operations
 type DDNSManager struct {
 	config *AppConfig
+
+	// Most recent reconcile report, kept for the dashboard
+	driftMutex  sync.RWMutex
+	lastDrift   []*DriftReport
+	lastDriftAt time.Time
+
+	history       *HistoryStore
+	notifications *NotificationManager
+	mqtt          *MQTTPublisher
+	events        *EventBroker
+
+	// Latest public IP lookup per record type, for the readiness check
+	ipMutex   sync.Mutex
+	ipLookups map[string]ipLookup
+
+	// Held while records are being updated, so scheduled, manual, MQTT
+	// and drift-fix runs never overlap and save the config over each other
+	updating sync.Mutex
+}
+
+// updateRunningMessage is the result of an update refused because another
+// one is still in progress
+const updateRunningMessage = "Another update is already running - try again shortly"
+
+// ipLookup is the outcome of one public IP lookup
+type ipLookup struct {
+	ip  string
+	at  time.Time
+	err error
 }
 
 func NewDDNSManager(config *AppConfig) *DDNSManager {
-	return &DDNSManager{config: config}
+	history := NewHistoryStore(config.HistoryPath(), config)
+	return &DDNSManager{
+		config:        config,
+		history:       history,
+		notifications: NewNotificationManager(config, history),
+		mqtt:          NewMQTTPublisher(config, history),
+		events:        NewEventBroker(),
+		ipLookups:     make(map[string]ipLookup),
+	}
+}
+
+// recordResult keeps the outcome of an update or fix in the history,
+// raises any notifications it calls for and publishes it over MQTT and the
+// live event stream
+func (dm *DDNSManager) recordResult(action string, record *DDNSRecord, result *UpdateResult) {
+	entry := historyEntryFor(action, record, result)
+	dm.recordHistory(entry)
+	dm.publishResult(entry, record)
+	if action == historyActionUpdate {
+		metrics.RecordUpdate(result)
+	}
+	dm.notifications.HandleResult(record, result)
+	dm.mqtt.PublishResult(record, result)
+}
+
+// GetPublicIP retrieves the current public IPv4 address
+func (dm *DDNSManager) GetPublicIP(ctx context.Context) (string, error) {
+	return dm.fetchPublicIP(ctx, "https://api.ipify.org")
+}
+
+// GetPublicIPv6 retrieves the current public IPv6 address
+func (dm *DDNSManager) GetPublicIPv6(ctx context.Context) (string, error) {
+	return dm.fetchPublicIP(ctx, "https://api6.ipify.org")
+}
+
+// GetPublicIPFor retrieves the public address matching a DNS record type
+func (dm *DDNSManager) GetPublicIPFor(ctx context.Context, recordType string) (ip string, err error) {
+	defer func() {
+		dm.ipMutex.Lock()
+		previous := dm.ipLookups[recordType].ip
+		dm.ipLookups[recordType] = ipLookup{ip: ip, at: time.Now(), err: err}
+		dm.ipMutex.Unlock()
+
+		if err == nil && ip != previous {
+			dm.events.Publish(streamPublicIP, map[string]string{"type": recordType, "ip": ip})
+		}
+	}()
+
+	if recordType == "AAAA" {
+		return dm.GetPublicIPv6(ctx)
+	}
+	return dm.GetPublicIP(ctx)
 }
 
-// GetPublicIP retrieves the current public IP address
-func (dm *DDNSManager) GetPublicIP() (string, error) {
-	resp, err := http.Get("https://api.ipify.org")
+// RecentPublicIP returns the latest lookup of a record type's public IP,
+// looking it up again if that is older than maxAge
+func (dm *DDNSManager) RecentPublicIP(ctx context.Context, recordType string, maxAge time.Duration) (string, time.Time, error) {
+	dm.ipMutex.Lock()
+	last, known := dm.ipLookups[recordType]
+	dm.ipMutex.Unlock()
+
+	if !known || time.Since(last.at) > maxAge {
+		ip, err := dm.GetPublicIPFor(ctx, recordType)
+		return ip, time.Now(), err
+	}
+	return last.ip, last.at, last.err
+}
+
+func (dm *DDNSManager) fetchPublicIP(ctx context.Context, sourceURL string) (ip string, err error) {
+	started := time.Now()
+	defer func() {
+		source := sourceURL
+		if parsed, parseErr := url.Parse(sourceURL); parseErr == nil {
+			source = parsed.Host
+		}
+		metrics.ObserveIPSource(source, time.Since(started), err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to get public IP: %v", err)
 	}
@@ -69,7 +201,7 @@ func (dm *DDNSManager) GetPublicIP() (string, error) {
 		return "", fmt.Errorf("failed to read response: %v", err)
 	}
 
-	ip := strings.TrimSpace(buf.String())
+	ip = strings.TrimSpace(buf.String())
 	if ip == "" {
 		return "", fmt.Errorf("empty IP response")
 	}
@@ -77,22 +209,6 @@ func (dm *DDNSManager) GetPublicIP() (string, error) {
 	return ip, nil
 }
 
-// GetDNSIP retrieves the current DNS IP for a record using dig
-func (dm *DDNSManager) GetDNSIP(recordName string) (string, error) {
-	cmd := exec.Command("dig", "+short", recordName, "@1.1.1.1")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to query DNS: %v", err)
-	}
-
-	ip := strings.TrimSpace(string(output))
-	if ip == "" {
-		return "", fmt.Errorf("no DNS record found")
-	}
-
-	return ip, nil
-}
-
 // ExtractZoneName extracts the zone name from a record name
 func (dm *DDNSManager) ExtractZoneName(recordName string) (string, error) {
 	parts := strings.Split(recordName, ".")
@@ -103,220 +219,342 @@ func (dm *DDNSManager) ExtractZoneName(recordName string) (string, error) {
 }
 
 // GetZoneID retrieves the zone ID for a domain
-func (dm *DDNSManager) GetZoneID(apiToken, zoneName string) (string, error) {
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones?name=%s", zoneName)
+func (dm *DDNSManager) GetZoneID(ctx context.Context, apiToken, zoneName string) (string, error) {
+	cfResp, err := dm.cloudflareRequest(ctx, "GET", "/zones?name="+url.QueryEscape(zoneName), apiToken, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var zones []CloudFlareZone
+	if err := json.Unmarshal(cfResp.Result, &zones); err != nil {
+		return "", fmt.Errorf("failed to parse zones: %v", err)
+	}
+
+	if len(zones) == 0 {
+		return "", fmt.Errorf("zone not found: %s", zoneName)
+	}
+
+	return zones[0].ID, nil
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// GetRecordID retrieves the ID of the record with the given name and type.
+// More than one match is an error rather than a guess.
+func (dm *DDNSManager) GetRecordID(ctx context.Context, apiToken, zoneID, recordName, recordType string) (string, error) {
+	path := fmt.Sprintf("/zones/%s/dns_records?name=%s&type=%s", zoneID, url.QueryEscape(recordName), url.QueryEscape(recordType))
+	cfResp, err := dm.cloudflareRequest(ctx, "GET", path, apiToken, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	var records []CloudFlareRecord
+	if err := json.Unmarshal(cfResp.Result, &records); err != nil {
+		return "", fmt.Errorf("failed to parse records: %v", err)
+	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	switch len(records) {
+	case 0:
+		return "", fmt.Errorf("%s record not found: %s", recordType, recordName)
+	case 1:
+		return records[0].ID, nil
+	default:
+		return "", fmt.Errorf("%d %s records named %s, pick one with the zone import", len(records), recordType, recordName)
+	}
+}
+
+// ListZoneRecords retrieves every A and AAAA record in a zone
+func (dm *DDNSManager) ListZoneRecords(ctx context.Context, apiToken, zoneID string) ([]CloudFlareRecord, error) {
+	var records []CloudFlareRecord
+
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/zones/%s/dns_records?per_page=100&page=%d", zoneID, page)
+		cfResp, err := dm.cloudflareRequest(ctx, "GET", path, apiToken, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var pageRecords []CloudFlareRecord
+		if err := json.Unmarshal(cfResp.Result, &pageRecords); err != nil {
+			return nil, fmt.Errorf("failed to parse records: %v", err)
+		}
+
+		for _, record := range pageRecords {
+			if record.Type == "A" || record.Type == "AAAA" {
+				records = append(records, record)
+			}
+		}
+
+		if cfResp.ResultInfo == nil || page >= cfResp.ResultInfo.TotalPages {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// GetDNSRecord retrieves a DNS record by ID. A record that no longer exists
+// at CloudFlare is reported as (nil, nil).
+func (dm *DDNSManager) GetDNSRecord(ctx context.Context, apiToken, zoneID, recordID string) (*CloudFlareRecord, error) {
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, recordID)
+	cfResp, err := dm.cloudflareRequest(ctx, "GET", path, apiToken, nil)
 	if err != nil {
-		return "", fmt.Errorf("API request failed: %v", err)
+		if cfResp != nil && cfResp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var cfResp CloudFlareResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	var record CloudFlareRecord
+	if err := json.Unmarshal(cfResp.Result, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse record: %v", err)
 	}
 
-	if !cfResp.Success {
-		return "", fmt.Errorf("CloudFlare API error: %v", cfResp.Errors)
+	return &record, nil
+}
+
+// CreateDNSRecord creates a new DNS record in a zone
+func (dm *DDNSManager) CreateDNSRecord(ctx context.Context, apiToken, zoneID string, record CloudFlareRecord) (*CloudFlareRecord, error) {
+	path := fmt.Sprintf("/zones/%s/dns_records", zoneID)
+	cfResp, err := dm.cloudflareRequest(ctx, "POST", path, apiToken, record)
+	if err != nil {
+		return nil, err
 	}
 
-	var zones []CloudFlareZone
-	if err := json.Unmarshal(cfResp.Result, &zones); err != nil {
-		return "", fmt.Errorf("failed to parse zones: %v", err)
+	var created CloudFlareRecord
+	if err := json.Unmarshal(cfResp.Result, &created); err != nil {
+		return nil, fmt.Errorf("failed to parse record: %v", err)
 	}
 
-	if len(zones) == 0 {
-		return "", fmt.Errorf("zone not found: %s", zoneName)
+	return &created, nil
+}
+
+// CreateTXTRecord publishes a TXT record, such as an ACME challenge, and
+// returns its ID for removal
+func (dm *DDNSManager) CreateTXTRecord(ctx context.Context, apiToken, zoneID, name, content string) (string, error) {
+	created, err := dm.CreateDNSRecord(ctx, apiToken, zoneID, CloudFlareRecord{
+		Type:    "TXT",
+		Name:    name,
+		Content: content,
+		TTL:     60,
+	})
+	if err != nil {
+		return "", err
 	}
+	return created.ID, nil
+}
 
-	return zones[0].ID, nil
+// DeleteDNSRecord removes a DNS record by ID
+func (dm *DDNSManager) DeleteDNSRecord(ctx context.Context, apiToken, zoneID, recordID string) error {
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", zoneID, recordID)
+	_, err := dm.cloudflareRequest(ctx, "DELETE", path, apiToken, nil)
+	return err
+}
+
+// putRecord overwrites a managed DNS record at CloudFlare with the given IP
+func (dm *DDNSManager) putRecord(ctx context.Context, record *DDNSRecord, ip string) error {
+	updateData := CloudFlareRecord{
+		Type:    record.Type(),
+		Name:    record.RecordName,
+		Content: ip,
+		TTL:     defaultRecordTTL,
+		Proxied: record.Proxied,
+	}
+
+	path := fmt.Sprintf("/zones/%s/dns_records/%s", record.ZoneID, record.RecordID)
+	slog.Debug("Updating record at CloudFlare", "record", record.RecordName, "zone", record.ZoneID, "path", path)
+
+	cfResp, err := dm.cloudflareRequest(ctx, "PUT", path, record.APIToken, updateData)
+	if cfResp != nil {
+		slog.Debug("CloudFlare responded", "record", record.RecordName, "zone", record.ZoneID, "status", cfResp.StatusCode)
+	}
+	return err
 }
 
-// GetRecordID retrieves the record ID for a DNS record
-func (dm *DDNSManager) GetRecordID(apiToken, zoneID, recordName string) (string, error) {
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s", zoneID, recordName)
+// cloudflareRequest performs an authenticated CloudFlare API call and decodes
+// the response envelope. The decoded response is returned alongside API
+// errors so callers can inspect the HTTP status.
+func (dm *DDNSManager) cloudflareRequest(ctx context.Context, method, path, apiToken string, body interface{}) (*CloudFlareResponse, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %v", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPIBase+path, reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+apiToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{Timeout: 30 * time.Second}
+	started := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("API request failed: %v", err)
+		metrics.ObserveProvider(method, 0, time.Since(started))
+		return nil, fmt.Errorf("API request failed: %v", err)
 	}
+	metrics.ObserveProvider(method, resp.StatusCode, time.Since(started))
 	defer resp.Body.Close()
 
 	var cfResp CloudFlareResponse
 	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
+	cfResp.StatusCode = resp.StatusCode
 
 	if !cfResp.Success {
-		return "", fmt.Errorf("CloudFlare API error: %v", cfResp.Errors)
-	}
-
-	var records []CloudFlareRecord
-	if err := json.Unmarshal(cfResp.Result, &records); err != nil {
-		return "", fmt.Errorf("failed to parse records: %v", err)
+		return &cfResp, fmt.Errorf("CloudFlare API error: %v", cfResp.Errors)
 	}
 
-	if len(records) == 0 {
-		return "", fmt.Errorf("record not found: %s", recordName)
-	}
-
-	return records[0].ID, nil
+	return &cfResp, nil
 }
 
 // UpdateRecord updates a single DNS record
-func (dm *DDNSManager) UpdateRecord(record *DDNSRecord) *UpdateResult {
+func (dm *DDNSManager) UpdateRecord(ctx context.Context, record *DDNSRecord) *UpdateResult {
 	result := &UpdateResult{
 		RecordName: record.RecordName,
+		RecordType: record.Type(),
 		UpdatedAt:  time.Now(),
 	}
+	defer dm.recordResult(historyActionUpdate, record, result)
 
-	log.Printf("🔄 Starting update for record: %s", record.RecordName)
+	logger := slog.With("record", record.RecordName, "zone", record.ZoneID)
+	started := time.Now()
+	elapsed := func() string { return time.Since(started).Round(time.Millisecond).String() }
+	logger.Debug("Starting update")
 
 	// Get current public IP
-	newIP, err := dm.GetPublicIP()
+	newIP, err := dm.GetPublicIPFor(ctx, record.Type())
 	if err != nil {
-		log.Printf("❌ Failed to get public IP for %s: %v", record.RecordName, err)
+		logger.Error("Failed to get public IP", "error", err)
 		result.Message = fmt.Sprintf("Failed to get public IP: %v", err)
 		return result
 	}
 	result.NewIP = newIP
-	log.Printf("📍 Current public IP: %s", newIP)
-
-	// Get current DNS IP
-	oldIP, err := dm.GetDNSIP(record.RecordName)
-	if err != nil {
-		// DNS query failed, but we can still try to update
-		log.Printf("⚠️ Failed to query current DNS IP for %s: %v", record.RecordName, err)
-		oldIP = "unknown"
-	} else {
-		log.Printf("🌐 Current DNS IP for %s: %s", record.RecordName, oldIP)
-	}
-	result.OldIP = oldIP
-
-	// Check if update is needed
-	if newIP == oldIP {
-		log.Printf("✅ No update needed for %s - IP unchanged (%s)", record.RecordName, newIP)
-		result.Success = true
-		result.Message = "No update needed - IP unchanged"
-		return result
-	}
-
-	log.Printf("🔄 IP change detected for %s: %s → %s", record.RecordName, oldIP, newIP)
+	logger.Debug("Current public IP", "new_ip", newIP)
 
 	// Validate record configuration
 	if record.ZoneID == "" {
-		log.Printf("❌ Missing zone ID for %s", record.RecordName)
+		logger.Error("Missing zone ID")
 		result.Message = "Missing zone ID - record configuration incomplete"
 		return result
 	}
 	if record.RecordID == "" {
-		log.Printf("❌ Missing record ID for %s", record.RecordName)
+		logger.Error("Missing record ID")
 		result.Message = "Missing record ID - record configuration incomplete"
 		return result
 	}
 	if record.APIToken == "" {
-		log.Printf("❌ Missing API token for %s", record.RecordName)
+		logger.Error("Missing API token")
 		result.Message = "Missing API token - record configuration incomplete"
 		return result
 	}
 
-	// Update the DNS record via CloudFlare API
-	updateData := map[string]interface{}{
-		"type":    "A",
-		"name":    record.RecordName,
-		"content": newIP,
-		"ttl":     300,
-		"proxied": record.Proxied,
+	// Compare with the record at CloudFlare rather than a public resolver,
+	// which answers with CloudFlare's edge addresses for proxied records.
+	// If it can't be read, the IP we last set is the best guess.
+	oldIP := record.LastIP
+	actual, err := dm.GetDNSRecord(ctx, record.APIToken, record.ZoneID, record.RecordID)
+	switch {
+	case err != nil:
+		logger.Warn("Failed to fetch current record, comparing with the last IP set", "error", err)
+	case actual == nil:
+		logger.Warn("Record is missing at CloudFlare")
+	default:
+		oldIP = actual.Content
+		logger.Debug("Current record IP", "old_ip", oldIP)
 	}
-
-	jsonData, err := json.Marshal(updateData)
-	if err != nil {
-		log.Printf("❌ Failed to marshal update data for %s: %v", record.RecordName, err)
-		result.Message = fmt.Sprintf("Failed to marshal update data: %v", err)
-		return result
+	result.OldIP = oldIP
+	if oldIP == "" {
+		result.OldIP = "unknown"
 	}
+	logger = logger.With("old_ip", result.OldIP, "new_ip", newIP)
 
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", record.ZoneID, record.RecordID)
-	log.Printf("🌐 Making API request to update %s: %s", record.RecordName, url)
-
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("❌ Failed to create HTTP request for %s: %v", record.RecordName, err)
-		result.Message = fmt.Sprintf("Failed to create request: %v", err)
+	// Check if update is needed
+	if newIP == oldIP {
+		logger.Info("No update needed - IP unchanged", "duration", elapsed())
+		result.Success = true
+		result.Message = "No update needed - IP unchanged"
 		return result
 	}
 
-	req.Header.Set("Authorization", "Bearer "+record.APIToken)
-	req.Header.Set("Content-Type", "application/json")
+	logger.Info("IP change detected")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("❌ API request failed for %s: %v", record.RecordName, err)
-		result.Message = fmt.Sprintf("API request failed: %v", err)
+	// Pre-update hooks may veto the change
+	if err := dm.runHooks(ctx, hookPreUpdate, record, result, hookStatusPending); err != nil {
+		logger.Warn("Update vetoed by pre-update hook", "error", err)
+		result.Message = fmt.Sprintf("Update vetoed by pre-update hook %v", err)
+		dm.runHooks(ctx, hookPostUpdate, record, result, hookStatusVetoed)
 		return result
 	}
-	defer resp.Body.Close()
-
-	log.Printf("📡 API response status for %s: %d", record.RecordName, resp.StatusCode)
 
-	var cfResp CloudFlareResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		log.Printf("❌ Failed to decode API response for %s: %v", record.RecordName, err)
-		result.Message = fmt.Sprintf("Failed to decode response: %v", err)
-		return result
-	}
-
-	if !cfResp.Success {
-		log.Printf("❌ CloudFlare API returned error for %s: %v", record.RecordName, cfResp.Errors)
-		result.Message = fmt.Sprintf("CloudFlare API error: %v", cfResp.Errors)
+	// Update the DNS record via CloudFlare API
+	if err := dm.putRecord(ctx, record, newIP); err != nil {
+		logger.Error("Failed to update record", "duration", elapsed(), "error", err)
+		result.Message = err.Error()
+		dm.runHooks(ctx, hookPostUpdate, record, result, hookStatusFailed)
 		return result
 	}
 
 	// Update succeeded
-	log.Printf("✅ Successfully updated %s: %s → %s", record.RecordName, oldIP, newIP)
+	logger.Info("Record updated", "duration", elapsed())
 	result.Success = true
+	result.Changed = oldIP != "" // Not when the previous IP couldn't be read
 	result.Message = "DNS record updated successfully"
 
 	// Update the record's last IP and timestamp
 	record.LastIP = newIP
 	record.LastUpdated = result.UpdatedAt.Format(time.RFC3339)
 
+	dm.runHooks(ctx, hookPostUpdate, record, result, hookStatusSuccess)
+
 	return result
 }
 
-// UpdateAllRecords updates all enabled DNS records
-func (dm *DDNSManager) UpdateAllRecords() []*UpdateResult {
+// UpdateAllRecords updates all enabled DNS records. It returns false
+// without doing anything if another update is still in progress.
+func (dm *DDNSManager) UpdateAllRecords(ctx context.Context) ([]*UpdateResult, bool) {
+	if !dm.updating.TryLock() {
+		return nil, false
+	}
+	defer dm.updating.Unlock()
+
 	var results []*UpdateResult
 
+	started := time.Now()
+	dm.events.Publish(streamCycleStarted, nil)
+
+	summary := cycleSummary{}
 	for i := range dm.config.Records {
 		record := &dm.config.Records[i]
 		if !record.Enabled {
 			continue
 		}
+		// Shutting down: leave the remaining records for the next run
+		if ctx.Err() != nil {
+			slog.Warn("Update cycle interrupted, skipping remaining records", "next", record.RecordName)
+			break
+		}
 
-		result := dm.UpdateRecord(record)
+		result := dm.UpdateRecord(ctx, record)
 		results = append(results, result)
+
+		summary.Records++
+		if !result.Success {
+			summary.Failed++
+			continue
+		}
+		summary.Succeeded++
+		if result.Changed {
+			summary.Changed++
+		}
 	}
+	summary.Duration = time.Since(started).Round(time.Millisecond).String()
+	dm.events.Publish(streamCycleFinished, summary)
 
 	// Save config to persist last IP and update times
 	if err := dm.config.save(); err != nil {
@@ -329,15 +567,27 @@ func (dm *DDNSManager) UpdateAllRecords() []*UpdateResult {
 		})
 	}
 
-	return results
+	return results, true
 }
 
 // AutoUpdateRecord automatically updates a specific record (used for scheduled updates)
-func (dm *DDNSManager) AutoUpdateRecord(recordName string) *UpdateResult {
-	record, err := dm.config.GetRecord(recordName)
+func (dm *DDNSManager) AutoUpdateRecord(ctx context.Context, recordName, recordType string) *UpdateResult {
+	if !dm.updating.TryLock() {
+		return &UpdateResult{
+			RecordName: recordName,
+			RecordType: recordType,
+			Success:    false,
+			Message:    updateRunningMessage,
+			UpdatedAt:  time.Now(),
+		}
+	}
+	defer dm.updating.Unlock()
+
+	record, err := dm.config.GetRecord(recordName, recordType)
 	if err != nil {
 		return &UpdateResult{
 			RecordName: recordName,
+			RecordType: recordType,
 			Success:    false,
 			Message:    fmt.Sprintf("Record not found: %v", err),
 			UpdatedAt:  time.Now(),
@@ -347,13 +597,14 @@ func (dm *DDNSManager) AutoUpdateRecord(recordName string) *UpdateResult {
 	if !record.Enabled {
 		return &UpdateResult{
 			RecordName: recordName,
+			RecordType: record.Type(),
 			Success:    true,
 			Message:    "Record disabled - skipped",
 			UpdatedAt:  time.Now(),
 		}
 	}
 
-	result := dm.UpdateRecord(record)
+	result := dm.UpdateRecord(ctx, record)
 
 	// Save config to persist updates
 	if result.Success {
@@ -366,7 +617,7 @@ func (dm *DDNSManager) AutoUpdateRecord(recordName string) *UpdateResult {
 }
 
 // ValidateRecord validates a DNS record configuration by testing API access
-func (dm *DDNSManager) ValidateRecord(record DDNSRecord) error {
+func (dm *DDNSManager) ValidateRecord(ctx context.Context, record DDNSRecord) error {
 	// Test API token by getting zone info
 	zoneName, err := dm.ExtractZoneName(record.RecordName)
 	if err != nil {
@@ -375,7 +626,7 @@ func (dm *DDNSManager) ValidateRecord(record DDNSRecord) error {
 
 	// Test zone access
 	if record.ZoneID == "" {
-		zoneID, err := dm.GetZoneID(record.APIToken, zoneName)
+		zoneID, err := dm.GetZoneID(ctx, record.APIToken, zoneName)
 		if err != nil {
 			return fmt.Errorf("failed to get zone ID: %v", err)
 		}
@@ -384,7 +635,7 @@ func (dm *DDNSManager) ValidateRecord(record DDNSRecord) error {
 
 	// Test record access
 	if record.RecordID == "" {
-		recordID, err := dm.GetRecordID(record.APIToken, record.ZoneID, record.RecordName)
+		recordID, err := dm.GetRecordID(ctx, record.APIToken, record.ZoneID, record.RecordName, record.Type())
 		if err != nil {
 			return fmt.Errorf("failed to get record ID: %v", err)
 		}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetRecordID(t *testing.T) {
+	zone := []CloudFlareRecord{
+		{ID: "a1", Name: "home.example.com", Type: "A"},
+		{ID: "aaaa1", Name: "home.example.com", Type: "AAAA"},
+		{ID: "rr1", Name: "rr.example.com", Type: "A"},
+		{ID: "rr2", Name: "rr.example.com", Type: "A"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matched := []CloudFlareRecord{}
+		for _, record := range zone {
+			if record.Name == r.URL.Query().Get("name") && (r.URL.Query().Get("type") == "" || record.Type == r.URL.Query().Get("type")) {
+				matched = append(matched, record)
+			}
+		}
+		result, _ := json.Marshal(matched)
+		json.NewEncoder(w).Encode(CloudFlareResponse{Success: true, Result: result})
+	}))
+	defer server.Close()
+	previous := cloudflareAPIBase
+	cloudflareAPIBase = server.URL
+	defer func() { cloudflareAPIBase = previous }()
+
+	tests := []struct {
+		name       string
+		recordType string
+		want       string
+		wantErr    string
+	}{
+		{"home.example.com", "A", "a1", ""},
+		{"home.example.com", "AAAA", "aaaa1", ""},
+		{"office.example.com", "A", "", "A record not found: office.example.com"},
+		{"rr.example.com", "AAAA", "", "AAAA record not found"},
+		{"rr.example.com", "A", "", "2 A records named rr.example.com"},
+	}
+
+	dm := NewDDNSManager(&AppConfig{})
+	for _, tt := range tests {
+		got, err := dm.GetRecordID(context.Background(), "token", "zone1", tt.name, tt.recordType)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GetRecordID(%s, %s) = %q, %v; want error %q", tt.name, tt.recordType, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("GetRecordID(%s, %s) = %q, %v; want %q", tt.name, tt.recordType, got, err, tt.want)
+		}
+	}
+}
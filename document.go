@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config documents are handled in two generic shapes: yaml.Node trees keep
+// key order (and comments) for anything written back out, while plain
+// map[string]interface{} trees are used for comparing and patching values.
+
+// isSecretField reports whether a config key holds a credential. parent is
+// the key of the object it sits in, looking through lists: every webhook
+// header may carry an API key, and a webhook URL a token in its query.
+func isSecretField(parent, key string) bool {
+	if parent == "headers" || (parent == "notifiers" && key == "url") {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, marker := range []string{"token", "password", "secret", "passphrase", "authorization", "webhook_url"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonToNode converts a JSON document into an order-preserving YAML node tree
+func jsonToNode(data []byte) (*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeJSONNode(dec)
+}
+
+func decodeJSONNode(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if t == '{' {
+			node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keyTok.(string)})
+			}
+			value, err := decodeJSONNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, value)
+		}
+		// Consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(t.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: t.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(t)}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+}
+
+// nodeToJSON converts a YAML node tree into indented JSON, keeping key order
+func nodeToJSON(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, node); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSONNode(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeJSONNode(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("unsupported value %q: %v", node.Value, err)
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+// marshalYAMLNode renders a node tree as YAML with two-space indentation
+func marshalYAMLNode(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactNode replaces every non-empty secret string in the tree. parent is
+// the key the node sits under, as for isSecretField.
+func redactNode(node *yaml.Node, parent string) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			redactNode(child, parent)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!str" && value.Value != "" && isSecretField(parent, key.Value) {
+				value.Value = redactedValue
+				continue
+			}
+			redactNode(value, key.Value)
+		}
+	}
+}
+
+// toGenericTree converts a value into maps, slices and scalars via JSON
+func toGenericTree(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// listItemKey returns the identity of a list element: records are matched
+// by name and type and other named entries by name, never by position
+func listItemKey(item interface{}) (string, bool) {
+	m, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	if name, ok := m["record_name"].(string); ok && name != "" {
+		recordType, _ := m["record_type"].(string)
+		return recordKey(name, recordType), true
+	}
+	if name, ok := m["name"].(string); ok && name != "" {
+		return name, true
+	}
+	return "", false
+}
+
+func findListItem(list []interface{}, key string) interface{} {
+	for _, item := range list {
+		if itemKey, ok := listItemKey(item); ok && itemKey == key {
+			return item
+		}
+	}
+	return nil
+}
+
+// ConfigChange describes one difference between two configurations
+type ConfigChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"` // added, removed or changed
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+func (c ConfigChange) String() string {
+	switch c.Action {
+	case "added":
+		return fmt.Sprintf("+ %s: %s", c.Path, c.After)
+	case "removed":
+		return fmt.Sprintf("- %s: %s", c.Path, c.Before)
+	default:
+		return fmt.Sprintf("~ %s: %s → %s", c.Path, c.Before, c.After)
+	}
+}
+
+// diffConfigValues compares two values (typically configs) and lists every
+// changed leaf, with secrets redacted
+func diffConfigValues(before, after interface{}) ([]ConfigChange, error) {
+	beforeTree, err := toGenericTree(before)
+	if err != nil {
+		return nil, err
+	}
+	afterTree, err := toGenericTree(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []ConfigChange
+	diffTrees("", "", "", beforeTree, afterTree, &changes)
+	return changes, nil
+}
+
+// diffTrees compares the values at path. key is the last key of the path and
+// parent the one before it, looking through lists, as for isSecretField.
+func diffTrees(path, parent, key string, before, after interface{}, changes *[]ConfigChange) {
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := make(map[string]bool)
+		for k := range beforeMap {
+			keys[k] = true
+		}
+		for k := range afterMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			diffTrees(joinConfigPath(path, k), key, k, beforeMap[k], afterMap[k], changes)
+		}
+		return
+	}
+
+	beforeList, beforeIsList := before.([]interface{})
+	afterList, afterIsList := after.([]interface{})
+	if beforeIsList && afterIsList && keyedList(beforeList) && keyedList(afterList) {
+		for _, item := range beforeList {
+			itemKey, _ := listItemKey(item)
+			diffTrees(fmt.Sprintf("%s[%s]", path, itemKey), parent, key, item, findListItem(afterList, itemKey), changes)
+		}
+		for _, item := range afterList {
+			itemKey, _ := listItemKey(item)
+			if findListItem(beforeList, itemKey) == nil {
+				diffTrees(fmt.Sprintf("%s[%s]", path, itemKey), parent, key, nil, item, changes)
+			}
+		}
+		return
+	}
+
+	change := ConfigChange{Path: path, Action: "changed"}
+	switch {
+	case before == nil:
+		change.Action = "added"
+	case after == nil:
+		change.Action = "removed"
+	}
+	if before != nil {
+		change.Before = describeConfigValue(parent, key, before)
+	}
+	if after != nil {
+		change.After = describeConfigValue(parent, key, after)
+	}
+	*changes = append(*changes, change)
+}
+
+// keyedList reports whether every element of a list has an identity key
+func keyedList(list []interface{}) bool {
+	for _, item := range list {
+		if _, ok := listItemKey(item); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describeConfigValue renders a value for a change list, hiding secrets
+func describeConfigValue(parent, key string, value interface{}) string {
+	if s, ok := value.(string); ok && isSecretField(parent, key) {
+		if s == "" {
+			return "(empty)"
+		}
+		return "(secret)"
+	}
+
+	redacted := redactTree(key, value)
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// redactTree returns a copy of a generic tree with secrets replaced. parent
+// is the key the tree sits under, as for isSecretField.
+func redactTree(parent string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if s, ok := item.(string); ok && s != "" && isSecretField(parent, k) {
+				out[k] = redactedValue
+				continue
+			}
+			out[k] = redactTree(k, item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactTree(parent, item)
+		}
+		return out
+	default:
+		return value
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix namespaces the variables that override config settings. Every
+// setting maps to DDNS_PILOT_ plus its JSON path in upper case, e.g.
+// web.session_timeout → DDNS_PILOT_WEB_SESSION_TIMEOUT.
+const envPrefix = "DDNS_PILOT_"
+
+// envAliases are additional variables honored for a setting
+var envAliases = map[string]string{
+	"web.port": "PORT",
+}
+
+// envOverride records a setting taken from the environment, along with the
+// value it replaced so saves keep the file's own value
+type envOverride struct {
+	Path        []string
+	Variable    string
+	Original    interface{}
+	HadOriginal bool
+}
+
+// applyEnvOverrides overrides settings from the environment and locks them
+// against edits. It can be called again after the config is replaced.
+func (c *AppConfig) applyEnvOverrides() error {
+	original, err := toGenericTree(c)
+	if err != nil {
+		return err
+	}
+
+	c.envOverrides = nil
+	var errs ValidationErrors
+	c.applyEnvToStruct(reflect.ValueOf(c).Elem(), nil, original, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// Report invalid values against the variable that set them
+	for _, problem := range validateConfig(c) {
+		for _, override := range c.envOverrides {
+			path := "$." + strings.Join(override.Path, ".")
+			if problem.Path == path || strings.HasPrefix(problem.Path, path+".") || strings.HasPrefix(problem.Path, path+"[") {
+				problem.Path += " (from " + override.Variable + ")"
+				errs = append(errs, problem)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// A password from the environment may be plaintext; it is only ever
+	// hashed in memory. It is never the default, so the forced change is
+	// skipped without recording that in the file.
+	if variable := c.EnvLocked("web.password"); variable != "" {
+		if !strings.HasPrefix(c.Web.Password, "$2") {
+			hashedPassword, err := HashPassword(c.Web.Password)
+			if err != nil {
+				return fmt.Errorf("failed to hash password: %v", err)
+			}
+			c.Web.Password = hashedPassword
+		}
+
+		path := []string{"web", "default_password_changed"}
+		originalValue, hadOriginal := lookupTreePath(original, path)
+		c.Web.DefaultPasswordChanged = true
+		c.envOverrides = append(c.envOverrides, envOverride{
+			Path:        path,
+			Variable:    variable,
+			Original:    originalValue,
+			HadOriginal: hadOriginal,
+		})
+	}
+
+	return nil
+}
+
+func (c *AppConfig) applyEnvToStruct(v reflect.Value, path []string, original interface{}, errs *ValidationErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" || name == "schema_version" {
+			continue
+		}
+
+		fieldPath := append(append([]string{}, path...), name)
+		fieldValue := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			c.applyEnvToStruct(fieldValue, fieldPath, original, errs)
+			continue
+		}
+
+		variable, raw, ok := lookupEnvSetting(fieldPath)
+		if !ok {
+			continue
+		}
+
+		if err := setFromEnv(fieldValue, raw); err != nil {
+			errs.add(variable, "%v", err)
+			continue
+		}
+
+		originalValue, hadOriginal := lookupTreePath(original, fieldPath)
+		c.envOverrides = append(c.envOverrides, envOverride{
+			Path:        fieldPath,
+			Variable:    variable,
+			Original:    originalValue,
+			HadOriginal: hadOriginal,
+		})
+	}
+}
+
+// envVariableFor returns the variable name for a config path
+func envVariableFor(path []string) string {
+	return envPrefix + strings.ToUpper(strings.Join(path, "_"))
+}
+
+func lookupEnvSetting(path []string) (string, string, bool) {
+	variable := envVariableFor(path)
+	if raw, ok := os.LookupEnv(variable); ok {
+		return variable, raw, true
+	}
+	if alias, ok := envAliases[strings.Join(path, ".")]; ok {
+		if raw, ok := os.LookupEnv(alias); ok && raw != "" {
+			return alias, raw, true
+		}
+	}
+	return "", "", false
+}
+
+// setFromEnv parses an environment value into a config field. Lists and
+// objects are given as JSON; string lists may also be comma-separated.
+func setFromEnv(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(raw, "[") {
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			v.Set(reflect.ValueOf(items))
+			return nil
+		}
+		return decodeEnvJSON(v, raw)
+	default:
+		return decodeEnvJSON(v, raw)
+	}
+	return nil
+}
+
+// decodeEnvJSON decodes a JSON value with the same strictness as the file
+func decodeEnvJSON(v reflect.Value, raw string) error {
+	var tree interface{}
+	if err := json.Unmarshal([]byte(raw), &tree); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	var errs ValidationErrors
+	checkConfigFields("$", tree, v.Type(), &errs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", errs[0])
+	}
+
+	// Records listed in the environment are enabled unless they say otherwise
+	if v.Type() == reflect.TypeOf([]DDNSRecord{}) {
+		var records []DDNSRecord
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			record := DDNSRecord{Enabled: true}
+			if err := json.Unmarshal(item, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		v.Set(reflect.ValueOf(records))
+		return nil
+	}
+
+	ptr := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// EnvLocked returns the variable controlling a setting (by dotted JSON
+// path, e.g. "web.port"), or "" if the setting is editable
+func (c *AppConfig) EnvLocked(path string) string {
+	for _, override := range c.envOverrides {
+		if strings.Join(override.Path, ".") == path {
+			return override.Variable
+		}
+	}
+	return ""
+}
+
+// restoreEnvOriginals puts the file's own values back in place of every
+// environment override before the config is written
+func (c *AppConfig) restoreEnvOriginals(tree map[string]interface{}) {
+	for _, override := range c.envOverrides {
+		if override.HadOriginal {
+			setTreePath(tree, override.Path, override.Original)
+		} else {
+			deleteTreePath(tree, override.Path)
+		}
+	}
+}
+
+func lookupTreePath(tree interface{}, path []string) (interface{}, bool) {
+	current := tree
+	for _, key := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setTreePath(tree map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := tree[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			tree[key] = next
+		}
+		tree = next
+	}
+	tree[path[len(path)-1]] = value
+}
+
+func deleteTreePath(tree map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := tree[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		tree = next
+	}
+	delete(tree, path[len(path)-1])
+}
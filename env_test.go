@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	t.Setenv("DDNS_PILOT_WEB_PORT", "9090")
+	t.Setenv("DDNS_PILOT_AUTO_UPDATE", "true")
+	t.Setenv("DDNS_PILOT_WEB_PASSWORD", "from-the-env")
+	t.Setenv("DDNS_PILOT_RECORDS", `[{"record_name": "home.example.com", "zone_id": "z1", "record_id": "r1", "api_token": "t"}]`)
+
+	config := newDefaultConfig()
+	config.Web.Password = "$2a$04$jnyWmWC1ZgsFAK3uOtNegOjEOUXk2bGIFXFfSW.TpyInje7l0yRBa"
+	if err := config.applyEnvOverrides(); err != nil {
+		t.Fatalf("applyEnvOverrides: %v", err)
+	}
+
+	if config.Web.Port != 9090 || !config.AutoUpdate {
+		t.Errorf("port %d, auto update %v; want 9090, true", config.Web.Port, config.AutoUpdate)
+	}
+	if len(config.Records) != 1 || !config.Records[0].Enabled {
+		t.Errorf("records = %+v, want one enabled record", config.Records)
+	}
+	if !strings.HasPrefix(config.Web.Password, "$2") || !ValidatePassword("from-the-env", config.Web.Password) {
+		t.Errorf("password from the environment was not hashed: %q", config.Web.Password)
+	}
+	if !config.Web.DefaultPasswordChanged {
+		t.Error("password from the environment still has to be changed")
+	}
+
+	for path, want := range map[string]string{
+		"web.port":                     "DDNS_PILOT_WEB_PORT",
+		"auto_update":                  "DDNS_PILOT_AUTO_UPDATE",
+		"records":                      "DDNS_PILOT_RECORDS",
+		"web.default_password_changed": "DDNS_PILOT_WEB_PASSWORD",
+		"update_interval":              "",
+	} {
+		if got := config.EnvLocked(path); got != want {
+			t.Errorf("EnvLocked(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	// The file keeps its own values for everything the environment set
+	data, err := config.persistedJSON()
+	if err != nil {
+		t.Fatalf("persistedJSON: %v", err)
+	}
+	var saved AppConfig
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if saved.Web.Port != 8082 || saved.AutoUpdate || len(saved.Records) != 0 || saved.Web.DefaultPasswordChanged {
+		t.Errorf("saved config carries environment values: %s", data)
+	}
+	if saved.Web.Password != "$2a$04$jnyWmWC1ZgsFAK3uOtNegOjEOUXk2bGIFXFfSW.TpyInje7l0yRBa" {
+		t.Errorf("saved password = %q, want the file's own", saved.Web.Password)
+	}
+}
+
+func TestApplyEnvOverridesAlias(t *testing.T) {
+	t.Setenv("PORT", "8443")
+	config := newDefaultConfig()
+	if err := config.applyEnvOverrides(); err != nil {
+		t.Fatalf("applyEnvOverrides: %v", err)
+	}
+	if config.Web.Port != 8443 || config.EnvLocked("web.port") != "PORT" {
+		t.Errorf("port %d locked by %q, want 8443 from PORT", config.Web.Port, config.EnvLocked("web.port"))
+	}
+
+	// The full variable name wins over the alias
+	t.Setenv("DDNS_PILOT_WEB_PORT", "9090")
+	if err := config.applyEnvOverrides(); err != nil {
+		t.Fatalf("applyEnvOverrides: %v", err)
+	}
+	if config.Web.Port != 9090 || config.EnvLocked("web.port") != "DDNS_PILOT_WEB_PORT" {
+		t.Errorf("port %d locked by %q, want 9090 from DDNS_PILOT_WEB_PORT", config.Web.Port, config.EnvLocked("web.port"))
+	}
+}
+
+func TestApplyEnvOverridesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		variable string
+		value    string
+		want     string
+	}{
+		{"not a number", "DDNS_PILOT_WEB_PORT", "eighty", `DDNS_PILOT_WEB_PORT: expected a whole number, got "eighty"`},
+		{"not a boolean", "DDNS_PILOT_AUTO_UPDATE", "sometimes", `DDNS_PILOT_AUTO_UPDATE: expected true or false, got "sometimes"`},
+		{"invalid JSON", "DDNS_PILOT_RECORDS", "[{", "DDNS_PILOT_RECORDS: invalid JSON"},
+		{"unknown field in JSON", "DDNS_PILOT_RECORDS", `[{"record_nme": "a.example.com"}]`, `DDNS_PILOT_RECORDS: $[0].record_nme: unknown field (did you mean "record_name"?)`},
+		{"invalid value", "DDNS_PILOT_WEB_PORT", "0", "$.web.port (from DDNS_PILOT_WEB_PORT): must be between 1 and 65535, got 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.variable, tt.value)
+			err := newDefaultConfig().applyEnvOverrides()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetFromEnvStringList(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"a, b,,c ", []string{"a", "b", "c"}},
+		{`["a, b", "c"]`, []string{"a, b", "c"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		if err := setFromEnv(reflect.ValueOf(&got).Elem(), tt.raw); err != nil {
+			t.Fatalf("setFromEnv(%q): %v", tt.raw, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("setFromEnv(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
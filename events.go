@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types sent over /api/v1/events
+const (
+	streamCycleStarted  = "cycle_started"
+	streamCycleFinished = "cycle_finished"
+	streamRecordResult  = "record_result"
+	streamIPChanged     = "ip_changed"
+	streamPublicIP      = "public_ip"
+	streamLog           = "log"
+)
+
+const (
+	eventReplaySize     = 100              // Events kept for clients reconnecting with Last-Event-ID
+	eventClientBuffer   = 64               // Events queued per client before it is considered stuck
+	eventHeartbeatEvery = 25 * time.Second // Keeps proxies from closing an idle stream
+)
+
+// Event is one message on the live event stream
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// EventBroker fans events out to every connected stream and keeps the last
+// few for clients that reconnect
+type EventBroker struct {
+	mutex       sync.Mutex
+	nextID      int64
+	recent      []Event
+	subscribers map[chan Event]map[string]bool // Event types wanted; nil for all
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan Event]map[string]bool)}
+}
+
+// Publish sends an event to every subscriber and keeps it for replay
+func (b *EventBroker) Publish(eventType string, data interface{}) {
+	b.send(eventType, data, true)
+}
+
+// Broadcast sends an event without keeping it for replay, for frequent
+// events such as log lines that would crowd out the rest
+func (b *EventBroker) Broadcast(eventType string, data interface{}) {
+	b.send(eventType, data, false)
+}
+
+// send delivers an event. A client that has fallen too far behind is
+// dropped rather than holding up updates; the browser reconnects and
+// catches up from the replay buffer.
+func (b *EventBroker) send(eventType string, data interface{}, keep bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now(), Data: data}
+
+	if keep {
+		b.recent = append(b.recent, event)
+		if len(b.recent) > eventReplaySize {
+			b.recent = b.recent[len(b.recent)-eventReplaySize:]
+		}
+	}
+
+	for ch, types := range b.subscribers {
+		if types != nil && !types[eventType] {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of events published after lastID (0 for only
+// new ones) and a function to stop receiving them. A non-nil types limits
+// the events to those types.
+func (b *EventBroker) Subscribe(lastID int64, types map[string]bool) (<-chan Event, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch := make(chan Event, eventClientBuffer+eventReplaySize)
+	if lastID > 0 {
+		for _, event := range b.recent {
+			if event.ID > lastID && (types == nil || types[event.Type]) {
+				ch <- event
+			}
+		}
+	}
+	b.subscribers[ch] = types
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Close ends every stream; the browsers reconnect to whatever serves the
+// dashboard next
+func (b *EventBroker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// cycleSummary is the data of a cycle_finished event
+type cycleSummary struct {
+	Records   int    `json:"records"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Changed   int    `json:"changed"`
+	Duration  string `json:"duration"`
+}
+
+// recordEvent is the data of a record_result event: the history entry plus
+// what the dashboard needs to redraw the record's row
+type recordEvent struct {
+	HistoryEntry
+	Status      string `json:"status"`
+	LastIP      string `json:"last_ip,omitempty"`
+	LastUpdated string `json:"last_updated,omitempty"`
+}
+
+// ipChange is the data of an ip_changed event
+type ipChange struct {
+	RecordName string `json:"record"`
+	RecordType string `json:"type"`
+	OldIP      string `json:"old_ip"`
+	NewIP      string `json:"new_ip"`
+}
+
+// publishResult announces the outcome of an update or fix
+func (dm *DDNSManager) publishResult(entry HistoryEntry, record *DDNSRecord) {
+	event := recordEvent{HistoryEntry: entry, Status: entry.Status()}
+	if record != nil {
+		event.LastIP = record.LastIP
+		event.LastUpdated = record.LastUpdated
+	}
+	dm.events.Publish(streamRecordResult, event)
+
+	if entry.Changed {
+		dm.events.Publish(streamIPChanged, ipChange{
+			RecordName: entry.RecordName,
+			RecordType: entry.RecordType,
+			OldIP:      entry.OldIP,
+			NewIP:      entry.NewIP,
+		})
+	}
+}
+
+// handleEvents streams events to the browser as Server-Sent Events
+func (p *DDNSPilot) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// ?types=record_result,log picks event types; the default is all
+	var types map[string]bool
+	if list := r.URL.Query().Get("types"); list != "" {
+		types = make(map[string]bool)
+		for _, eventType := range strings.Split(list, ",") {
+			types[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	// EventSource sends the last ID it saw when it reconnects
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	events, unsubscribe := p.ddns.events.Subscribe(lastID, types)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatEvery)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// End the stream once the session behind it has expired
+			cookie, err := r.Cookie("session_id")
+			if err != nil {
+				return
+			}
+			if _, valid := sessionManager.GetSession(cookie.Value); !valid {
+				return
+			}
+			fmt.Fprintf(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Warn("Failed to encode event", "type", event.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported config file formats, picked by file extension
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormatFor returns the format of a config file from its extension
+func configFormatFor(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return formatJSON, nil
+	case ".yaml", ".yml":
+		return formatYAML, nil
+	case ".toml":
+		return formatTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q (use .json, .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+}
+
+// configDocumentToJSON converts a config file in any supported format into
+// JSON, which migration, validation and decoding work on
+func configDocumentToJSON(path string, data []byte) ([]byte, error) {
+	format, err := configFormatFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case formatYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %v", err)
+		}
+		if node.Kind == 0 {
+			return []byte("{}"), nil
+		}
+		return nodeToJSON(&node)
+	case formatTOML:
+		var tree map[string]interface{}
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %v", err)
+		}
+		return json.Marshal(tree)
+	default:
+		return data, nil
+	}
+}
+
+// encodeConfigDocument renders a JSON config document in the format of
+// path. For YAML, the previous file contents are used as a template so
+// comments and layout written by hand survive saves from the web UI.
+func encodeConfigDocument(path string, jsonData, previous []byte) ([]byte, error) {
+	format, err := configFormatFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case formatYAML:
+		updated, err := jsonToNode(jsonData)
+		if err != nil {
+			return nil, err
+		}
+
+		var existing yaml.Node
+		if len(previous) > 0 && yaml.Unmarshal(previous, &existing) == nil &&
+			existing.Kind == yaml.DocumentNode && len(existing.Content) == 1 {
+			existing.Content[0] = mergeYAMLNode(existing.Content[0], updated)
+			return marshalYAMLNode(&existing)
+		}
+		return marshalYAMLNode(updated)
+	case formatTOML:
+		var tree map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(jsonData))
+		dec.UseNumber()
+		if err := dec.Decode(&tree); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(toTOMLValues(tree)); err != nil {
+			return nil, fmt.Errorf("failed to encode TOML: %v", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, jsonData, "", "  "); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// mergeYAMLNode returns updated's values laid onto existing, keeping the
+// existing nodes (and so their comments and style) wherever they still apply
+func mergeYAMLNode(existing, updated *yaml.Node) *yaml.Node {
+	if existing.Kind != updated.Kind {
+		updated.HeadComment = existing.HeadComment
+		updated.LineComment = existing.LineComment
+		updated.FootComment = existing.FootComment
+		return updated
+	}
+
+	switch updated.Kind {
+	case yaml.MappingNode:
+		existingValues := make(map[string]int)
+		for i := 0; i+1 < len(existing.Content); i += 2 {
+			existingValues[existing.Content[i].Value] = i
+		}
+
+		content := make([]*yaml.Node, 0, len(updated.Content))
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			key, value := updated.Content[i], updated.Content[i+1]
+			if j, ok := existingValues[key.Value]; ok {
+				content = append(content, existing.Content[j], mergeYAMLNode(existing.Content[j+1], value))
+			} else {
+				content = append(content, key, value)
+			}
+		}
+		existing.Content = content
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, 0, len(updated.Content))
+		for i, item := range updated.Content {
+			if match := matchYAMLItem(existing.Content, item, i); match != nil {
+				content = append(content, mergeYAMLNode(match, item))
+			} else {
+				content = append(content, item)
+			}
+		}
+		existing.Content = content
+	default:
+		if existing.Tag != updated.Tag {
+			existing.Style = 0
+		}
+		existing.Tag = updated.Tag
+		existing.Value = updated.Value
+	}
+
+	return existing
+}
+
+// matchYAMLItem finds the existing list element an updated one replaces:
+// records by name and type, other named entries by their name, anything
+// else by position
+func matchYAMLItem(existing []*yaml.Node, item *yaml.Node, index int) *yaml.Node {
+	if key := yamlItemKey(item); key != "" {
+		for _, candidate := range existing {
+			if yamlItemKey(candidate) == key {
+				return candidate
+			}
+		}
+		return nil
+	}
+	if index < len(existing) {
+		return existing[index]
+	}
+	return nil
+}
+
+func yamlItemKey(node *yaml.Node) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		fields[node.Content[i].Value] = node.Content[i+1].Value
+	}
+	if name := fields["record_name"]; name != "" {
+		return "record_name=" + recordKey(name, fields["record_type"])
+	}
+	if name := fields["name"]; name != "" {
+		return "name=" + name
+	}
+	return ""
+}
+
+// toTOMLValues prepares a generic JSON tree for TOML: numbers keep their
+// integer type and nulls, which TOML cannot express, are left out
+func toTOMLValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = toTOMLValues(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = toTOMLValues(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
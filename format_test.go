@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormatFor(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"ddns-pilot.json", formatJSON, false},
+		{"/etc/ddns-pilot/config.yaml", formatYAML, false},
+		{"config.YML", formatYAML, false},
+		{"config.toml", formatTOML, false},
+		{"config.ini", "", true},
+		{"config", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := configFormatFor(tt.path)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("configFormatFor(%q) = %q, %v; want %q, error %v", tt.path, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestConfigFormatRoundTrip saves a config as JSON, converts it to YAML and
+// then TOML through the same paths load and save use, and expects the same
+// config back at every step
+func TestConfigFormatRoundTrip(t *testing.T) {
+	config := testBundleConfig()
+	config.Records = append(config.Records, DDNSRecord{
+		RecordName: "home.example.com",
+		RecordType: "AAAA",
+		APIToken:   "cf-token-secret",
+		ZoneID:     "zone1",
+		RecordID:   "rec2",
+		Proxied:    true,
+	})
+	config.Web.TrustedProxies = []string{"10.0.0.0/8"}
+	config.UpdateInterval = 15
+	want := mustPersistedJSON(t, config)
+
+	current := []byte(want)
+	for _, path := range []string{"c.json", "c.yaml", "c.toml", "c.json"} {
+		data, err := encodeConfigDocument(path, current, nil)
+		if err != nil {
+			t.Fatalf("encode %s: %v", path, err)
+		}
+		jsonData, err := configDocumentToJSON(path, data)
+		if err != nil {
+			t.Fatalf("read back %s: %v\n%s", path, err, data)
+		}
+		decoded, _, err := decodeConfigDocument(jsonData)
+		if err != nil {
+			t.Fatalf("decode %s: %v\n%s", path, err, data)
+		}
+
+		got := mustPersistedJSON(t, decoded)
+		if got != want {
+			t.Fatalf("%s changed the config\n got: %s\nwant: %s", path, got, want)
+		}
+		current = []byte(got)
+	}
+}
+
+func TestConfigDocumentToJSONErrors(t *testing.T) {
+	tests := []struct {
+		path string
+		data string
+		want string
+	}{
+		{"c.yaml", "records: [", "invalid YAML"},
+		{"c.toml", "records = [", "invalid TOML"},
+		{"c.ini", "", "unsupported config file extension"},
+	}
+
+	for _, tt := range tests {
+		if _, err := configDocumentToJSON(tt.path, []byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("configDocumentToJSON(%s) error = %v, want %q", tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeYAMLKeepsComments(t *testing.T) {
+	previous := `# DDNS Pilot settings
+update_interval: 5 # minutes
+auto_update: false
+
+# Records, in the order I like them
+records:
+  # The main one
+  - record_name: b.example.com
+    enabled: true
+  - record_name: a.example.com # backup line
+    enabled: true
+    notes: old
+web:
+  port: 8082
+`
+
+	tests := []struct {
+		name     string
+		updated  string
+		contains []string
+		missing  []string
+	}{
+		{
+			name:     "changed values keep their comments",
+			updated:  `{"update_interval": 10, "auto_update": true, "records": [{"record_name": "b.example.com", "enabled": true}, {"record_name": "a.example.com", "enabled": false, "notes": "old"}], "web": {"port": 8082}}`,
+			contains: []string{"# DDNS Pilot settings", "update_interval: 10 # minutes", "auto_update: true", "# Records, in the order I like them", "# The main one\n  - record_name: b.example.com", "record_name: a.example.com # backup line", "enabled: false"},
+		},
+		{
+			name:     "records are matched by name, not position",
+			updated:  `{"update_interval": 5, "auto_update": false, "records": [{"record_name": "a.example.com", "enabled": true, "notes": "old"}, {"record_name": "b.example.com", "enabled": true}], "web": {"port": 8082}}`,
+			contains: []string{"- record_name: a.example.com # backup line", "# The main one\n  - record_name: b.example.com"},
+		},
+		{
+			name:     "removed entries and keys go, new ones are added",
+			updated:  `{"update_interval": 5, "auto_update": false, "records": [{"record_name": "b.example.com", "enabled": true}, {"record_name": "c.example.com", "enabled": true}], "web": {"port": 8082, "base_path": "/ddns"}}`,
+			contains: []string{"# The main one\n  - record_name: b.example.com", "record_name: c.example.com", "base_path: /ddns"},
+			missing:  []string{"a.example.com", "backup line"},
+		},
+		{
+			name:     "a value changing kind keeps its comment",
+			updated:  `{"update_interval": 5, "auto_update": false, "records": null, "web": {"port": 8082}}`,
+			contains: []string{"# Records, in the order I like them", "records: null"},
+			missing:  []string{"# The main one"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeConfigDocument("c.yaml", []byte(tt.updated), []byte(previous))
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			out := string(data)
+			for _, want := range tt.contains {
+				if !strings.Contains(out, want) {
+					t.Errorf("missing %q in:\n%s", want, out)
+				}
+			}
+			for _, unwanted := range tt.missing {
+				if strings.Contains(out, unwanted) {
+					t.Errorf("unexpected %q in:\n%s", unwanted, out)
+				}
+			}
+
+			// Whatever the layout, the values are the updated ones
+			jsonData, err := configDocumentToJSON("c.yaml", data)
+			if err != nil {
+				t.Fatalf("read back: %v", err)
+			}
+			assertSameJSON(t, jsonData, []byte(tt.updated))
+		})
+	}
+}
+
+// TestEncodeYAMLDualStackRecords checks that an A and an AAAA record of the
+// same name each keep their own entry and comments
+func TestEncodeYAMLDualStackRecords(t *testing.T) {
+	previous := `records:
+  - record_name: h.example.com # IPv4
+    record_id: a
+  - record_name: h.example.com # IPv6
+    record_type: AAAA
+    record_id: b
+`
+	updated := `{"records": [{"record_name": "h.example.com", "record_type": "AAAA", "record_id": "b2"}, {"record_name": "h.example.com", "record_id": "a"}]}`
+
+	data, err := encodeConfigDocument("c.yaml", []byte(updated), []byte(previous))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	for _, want := range []string{"h.example.com # IPv6\n    record_type: AAAA\n    record_id: b2", "h.example.com # IPv4\n    record_id: a\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in:\n%s", want, data)
+		}
+	}
+
+	jsonData, err := configDocumentToJSON("c.yaml", data)
+	if err != nil {
+		t.Fatalf("read back: %v", err)
+	}
+	assertSameJSON(t, jsonData, []byte(updated))
+}
+
+func TestEncodeYAMLWithoutUsablePrevious(t *testing.T) {
+	updated := `{"update_interval": 5, "records": []}`
+	for _, previous := range []string{"", "not: [valid", "- a\n- list\n"} {
+		data, err := encodeConfigDocument("c.yaml", []byte(updated), []byte(previous))
+		if err != nil {
+			t.Fatalf("encode with previous %q: %v", previous, err)
+		}
+		jsonData, err := configDocumentToJSON("c.yaml", data)
+		if err != nil {
+			t.Fatalf("read back: %v", err)
+		}
+		assertSameJSON(t, jsonData, []byte(updated))
+	}
+}
+
+// assertSameJSON compares two JSON documents by value
+func assertSameJSON(t *testing.T, got, want []byte) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("parse %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("parse %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("documents differ\n got: %s\nwant: %s", got, want)
+	}
+}
@@ -2,4 +2,8 @@ module ddns-pilot
 
 go 1.23.0
 
-require golang.org/x/crypto v0.40.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/crypto v0.40.0
+	gopkg.in/yaml.v3 v3.0.1
+)
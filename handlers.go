@@ -3,9 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"net"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -26,43 +27,34 @@ func sessionAuth(next http.HandlerFunc, config *AppConfig) http.HandlerFunc {
 		cookie, err := r.Cookie("session_id")
 		if err != nil {
 			// No session cookie, redirect to login
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			http.Redirect(w, r, config.URL("/login"), http.StatusSeeOther)
 			return
 		}
 
 		// Validate session
-		_, valid := sessionManager.GetSession(cookie.Value)
+		session, valid := sessionManager.GetSession(cookie.Value)
 		if !valid {
 			// Invalid session, redirect to login
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session_id",
-				Value:    "",
-				Expires:  time.Unix(0, 0),
-				HttpOnly: true,
-				Secure:   r.TLS != nil,
-				SameSite: http.SameSiteStrictMode,
-				Path:     "/",
-			})
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			http.SetCookie(w, newCookie(r, config, "session_id", "", -1))
+			http.Redirect(w, r, config.URL("/login"), http.StatusSeeOther)
 			return
 		}
 
+		// Scripts using the session read the CSRF token from here
+		w.Header().Set(csrfHeader, session.CSRFToken)
 		next(w, r)
 	}
 }
 
 func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Get client IP for rate limiting
-	clientIP := r.RemoteAddr
-	if host, _, err := net.SplitHostPort(clientIP); err == nil {
-		clientIP = host
-	}
+	clientIP := clientIP(r)
 
 	if r.Method == "GET" {
 		// Check if already logged in
 		if cookie, err := r.Cookie("session_id"); err == nil {
 			if _, valid := sessionManager.GetSession(cookie.Value); valid {
-				http.Redirect(w, r, "/", http.StatusSeeOther)
+				http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 				return
 			}
 		}
@@ -71,6 +63,7 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 		isBlocked := rateLimiter.IsBlocked(clientIP)
 
 		data := struct {
+			page
 			Error                string
 			IsBlocked            bool
 			UsingDefaultPassword bool
@@ -80,14 +73,19 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 			UsingDefaultPassword: !p.config.Web.DefaultPasswordChanged,
 		}
 
-		renderTemplate(w, "login.html", data)
+		p.renderTemplate(w, r, "login.html", &data)
 		return
 	}
 
 	if r.Method == "POST" {
 		// Check if IP is blocked
+		// The block itself was audited when it started; attempts made
+		// while it lasts aren't, so they can't flood the audit log
 		if rateLimiter.IsBlocked(clientIP) {
+			slog.Debug("Rejected login attempt while blocked", "client", clientIP)
+
 			data := struct {
+				page
 				Error                string
 				IsBlocked            bool
 				UsingDefaultPassword bool
@@ -97,7 +95,7 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 				UsingDefaultPassword: !p.config.Web.DefaultPasswordChanged,
 			}
 
-			renderTemplate(w, "login.html", data)
+			p.renderTemplate(w, r, "login.html", &data)
 			return
 		}
 
@@ -108,9 +106,28 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 		// Validate credentials
 		if username != "admin" || !ValidatePassword(password, p.config.Web.Password) {
 			// Record failed attempt
-			rateLimiter.RecordFailedAttempt(clientIP)
+			blocked := rateLimiter.RecordFailedAttempt(clientIP)
+			metrics.loginFailures.Inc()
+
+			actor := loginActor(r)
+			p.audit.Record(AuditEntry{
+				Actor:    actor,
+				SourceIP: clientIP,
+				Action:   auditLoginFailed,
+				Details:  "invalid username or password",
+			})
+			if blocked {
+				slog.Warn("Blocking client after repeated failed logins", "client", clientIP)
+				p.audit.Record(AuditEntry{
+					Actor:    actor,
+					SourceIP: clientIP,
+					Action:   auditLoginBlocked,
+					Details:  "blocked for 15 minutes after 5 failed attempts",
+				})
+			}
 
 			data := struct {
+				page
 				Error                string
 				IsBlocked            bool
 				UsingDefaultPassword bool
@@ -120,17 +137,23 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 				UsingDefaultPassword: !p.config.Web.DefaultPasswordChanged,
 			}
 
-			renderTemplate(w, "login.html", data)
+			p.renderTemplate(w, r, "login.html", &data)
 			return
 		}
 
 		// Record successful login (clears failed attempts)
 		rateLimiter.RecordSuccessfulLogin(clientIP)
+		p.audit.Record(AuditEntry{
+			Actor:    username,
+			SourceIP: clientIP,
+			Action:   auditLogin,
+			Success:  true,
+		})
 
 		// Check if using default password (admin/admin)
 		if !p.config.Web.DefaultPasswordChanged && ValidatePassword("admin", p.config.Web.Password) {
 			// Force password change
-			http.Redirect(w, r, "/change-password?force=true", http.StatusSeeOther)
+			http.Redirect(w, r, p.config.URL("/change-password?force=true"), http.StatusSeeOther)
 			return
 		}
 
@@ -141,16 +164,9 @@ func (p *DDNSPilot) handleLogin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_id",
-			Value:    session.ID,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false, // Set to true in production with HTTPS
-			MaxAge:   p.config.Web.SessionTimeout * 60,
-		})
+		http.SetCookie(w, newCookie(r, p.config, "session_id", session.ID, p.config.Web.SessionTimeout*60))
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 	}
 }
 
@@ -160,12 +176,17 @@ func (p *DDNSPilot) handleChangePassword(w http.ResponseWriter, r *http.Request)
 	switch r.Method {
 	case "GET":
 		data := struct {
+			page
 			Forced bool
 		}{
 			Forced: forced,
 		}
-		renderTemplate(w, "change-password.html", data)
+		p.renderTemplate(w, r, "change-password.html", &data)
 	case "POST":
+		if refuseIfEnvLocked(w, p.config, "web.password") {
+			return
+		}
+
 		newPassword := r.FormValue("new_password")
 		confirmPassword := r.FormValue("confirm_password")
 		acknowledged := r.FormValue("security_acknowledged") == "on"
@@ -198,23 +219,30 @@ func (p *DDNSPilot) handleChangePassword(w http.ResponseWriter, r *http.Request)
 		// Hash the new password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 		if err != nil {
-			log.Printf("Error hashing password: %v", err)
+			slog.Error("Failed to hash password", "error", err)
 			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 			return
 		}
 
 		// Update configuration
+		before := p.snapshotConfig()
 		p.config.Web.Password = string(hashedPassword)
 		p.config.Web.DefaultPasswordChanged = true
 		p.config.Web.SecurityAcknowledged = acknowledged
 
 		// Save configuration
 		if err := p.config.save(); err != nil {
-			log.Printf("Error saving config: %v", err)
+			slog.Error("Failed to save config", "error", err)
 			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
 			return
 		}
 
+		details := ""
+		if forced {
+			details = "replaced the default password"
+		}
+		p.auditRequest(r, auditPasswordChange, "", details, before)
+
 		// If this was a forced change, create session and redirect to dashboard
 		if forced {
 			session, err := sessionManager.CreateSession("admin", p.config.Web.SessionTimeout)
@@ -223,23 +251,24 @@ func (p *DDNSPilot) handleChangePassword(w http.ResponseWriter, r *http.Request)
 				return
 			}
 
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session_id",
-				Value:    session.ID,
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   false,
-				MaxAge:   p.config.Web.SessionTimeout * 60,
-			})
+			http.SetCookie(w, newCookie(r, p.config, "session_id", session.ID, p.config.Web.SessionTimeout*60))
 		}
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func (p *DDNSPilot) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// POST only, so a link or image on another site can't log the user out
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.auditRequest(r, auditLogout, "", "", nil)
+
 	// Get session cookie
 	if cookie, err := r.Cookie("session_id"); err == nil {
 		// Delete session
@@ -247,22 +276,14 @@ func (p *DDNSPilot) handleLogout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Clear session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	http.SetCookie(w, newCookie(r, p.config, "session_id", "", -1))
 
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	http.Redirect(w, r, p.config.URL("/login"), http.StatusSeeOther)
 }
 
 func (p *DDNSPilot) handleIndex(w http.ResponseWriter, r *http.Request) {
 	// Get current public IP for display
-	currentIP, _ := p.ddns.GetPublicIP()
+	currentIP, _ := p.ddns.GetPublicIP(r.Context())
 
 	// Check for update result messages
 	updateResult := r.URL.Query().Get("update_result")
@@ -288,26 +309,67 @@ func (p *DDNSPilot) handleIndex(w http.ResponseWriter, r *http.Request) {
 		errorMsg := r.URL.Query().Get("error")
 		updateMessage = fmt.Sprintf("❌ Failed to update %s: %s", record, errorMsg)
 		updateType = "error"
+	case "imported":
+		imported := r.URL.Query().Get("imported")
+		skipped := r.URL.Query().Get("skipped")
+		updateMessage = fmt.Sprintf("✅ Imported %s record(s)", imported)
+		updateType = "success"
+		if skipped != "" && skipped != "0" {
+			updateMessage = fmt.Sprintf("⚠️ Imported %s record(s), skipped %s already managed", imported, skipped)
+			updateType = "warning"
+		}
+	case "busy":
+		updateMessage = "⏳ " + updateRunningMessage
+		updateType = "warning"
+	case "drift":
+		drifted := r.URL.Query().Get("drifted")
+		if drifted == "0" {
+			updateMessage = "✅ All records match CloudFlare"
+			updateType = "success"
+		} else {
+			updateMessage = fmt.Sprintf("⚠️ Drift detected on %s record(s)", drifted)
+			updateType = "warning"
+		}
+	}
+
+	driftReports, driftCheckedAt := p.ddns.LastDriftReport()
+
+	timelines, err := p.ddns.history.Timelines(10)
+	if err != nil {
+		slog.Warn("Failed to read history", "error", err)
 	}
 
 	data := struct {
-		Records       []DDNSRecord
-		CurrentIP     string
-		Config        *AppConfig
-		UpdateMessage string
-		UpdateType    string
+		page
+		Records        []DDNSRecord
+		CurrentIP      string
+		Config         *AppConfig
+		UpdateMessage  string
+		UpdateType     string
+		DriftReports   []*DriftReport
+		DriftCheckedAt string
+		Timelines      map[string][]HistoryEntry
 	}{
 		Records:       p.config.Records,
 		CurrentIP:     currentIP,
 		Config:        p.config,
 		UpdateMessage: updateMessage,
 		UpdateType:    updateType,
+		DriftReports:  driftReports,
+		Timelines:     timelines,
+	}
+	if !driftCheckedAt.IsZero() {
+		data.DriftCheckedAt = driftCheckedAt.Format(time.RFC3339)
 	}
 
-	renderTemplate(w, "index.html", data)
+	p.renderTemplate(w, r, "index.html", &data)
 }
 
 func (p *DDNSPilot) handleAddRecord(w http.ResponseWriter, r *http.Request) {
+	if refuseIfEnvLocked(w, p.config, "records") {
+		return
+	}
+
 	if r.Method == "POST" {
 		r.ParseForm()
 
@@ -318,8 +380,8 @@ func (p *DDNSPilot) handleAddRecord(w http.ResponseWriter, r *http.Request) {
 			Notes:      strings.TrimSpace(r.FormValue("notes")),
 		}
 
-		if record.RecordName == "" {
-			http.Error(w, "Record name cannot be empty", http.StatusBadRequest)
+		if err := validateHostname(record.RecordName); err != nil {
+			http.Error(w, "Invalid record name: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -329,7 +391,7 @@ func (p *DDNSPilot) handleAddRecord(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check if record already exists
-		if _, err := p.config.GetRecord(record.RecordName); err == nil {
+		if _, err := p.config.GetRecord(record.RecordName, record.Type()); err == nil {
 			http.Error(w, "Record already exists", http.StatusConflict)
 			return
 		}
@@ -341,14 +403,14 @@ func (p *DDNSPilot) handleAddRecord(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		zoneID, err := p.ddns.GetZoneID(record.APIToken, zoneName)
+		zoneID, err := p.ddns.GetZoneID(r.Context(), record.APIToken, zoneName)
 		if err != nil {
 			http.Error(w, "Failed to get zone ID: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 		record.ZoneID = zoneID
 
-		recordID, err := p.ddns.GetRecordID(record.APIToken, record.ZoneID, record.RecordName)
+		recordID, err := p.ddns.GetRecordID(r.Context(), record.APIToken, record.ZoneID, record.RecordName, record.Type())
 		if err != nil {
 			http.Error(w, "Failed to get record ID: "+err.Error(), http.StatusBadRequest)
 			return
@@ -356,47 +418,106 @@ func (p *DDNSPilot) handleAddRecord(w http.ResponseWriter, r *http.Request) {
 		record.RecordID = recordID
 
 		// Add the record
+		before := p.snapshotConfig()
 		p.config.AddRecord(record)
 
 		if err := p.config.save(); err != nil {
 			http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		p.auditRequest(r, auditRecordAdd, record.RecordName, "", before)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 		return
 	}
 
 	data := struct {
+		page
 		DefaultAPIToken string
 	}{
 		DefaultAPIToken: p.config.DefaultAPIToken,
 	}
 
-	renderTemplate(w, "add-record.html", data)
+	p.renderTemplate(w, r, "add-record.html", &data)
+}
+
+func (p *DDNSPilot) handleImportRecords(w http.ResponseWriter, r *http.Request) {
+	if refuseIfEnvLocked(w, p.config, "records") {
+		return
+	}
+
+	data := struct {
+		page
+		APIToken   string
+		ZoneName   string
+		Error      string
+		ZoneImport *ZoneImport
+	}{
+		APIToken: p.config.DefaultAPIToken,
+	}
+
+	if r.Method == "POST" {
+		r.ParseForm()
+		data.APIToken = strings.TrimSpace(r.FormValue("api_token"))
+		data.ZoneName = strings.TrimSpace(r.FormValue("zone_name"))
+
+		// Always re-list the zone so only real CloudFlare records are imported
+		zoneImport, err := p.ddns.ListImportCandidates(r.Context(), data.APIToken, data.ZoneName)
+		if err != nil {
+			data.Error = err.Error()
+			p.renderTemplate(w, r, "import-records.html", &data)
+			return
+		}
+		data.ZoneImport = zoneImport
+
+		if r.FormValue("action") == "import" {
+			before := p.snapshotConfig()
+			summary := p.config.ImportRecords(zoneImport, r.Form["record_id"])
+
+			if len(summary.Imported) > 0 {
+				if err := p.config.save(); err != nil {
+					http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				p.auditRequest(r, auditRecordsImport, zoneImport.ZoneName, fmt.Sprintf("imported %d, skipped %d", len(summary.Imported), len(summary.Skipped)), before)
+			}
+
+			slog.Info("Imported records from zone", "zone", zoneImport.ZoneName, "count", len(summary.Imported))
+			http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=imported&imported=%d&skipped=%d", len(summary.Imported), len(summary.Skipped))), http.StatusSeeOther)
+			return
+		}
+	}
+
+	p.renderTemplate(w, r, "import-records.html", &data)
 }
 
 func (p *DDNSPilot) handleEditRecord(w http.ResponseWriter, r *http.Request) {
 	recordName := r.URL.Query().Get("name")
+	recordType := r.URL.Query().Get("type")
 	if recordName == "" {
 		http.Error(w, "Record name required", http.StatusBadRequest)
 		return
 	}
 
-	record, err := p.config.GetRecord(recordName)
+	record, err := p.config.GetRecord(recordName, recordType)
 	if err != nil {
 		http.Error(w, "Record not found", http.StatusNotFound)
 		return
 	}
 
+	if refuseIfEnvLocked(w, p.config, "records") {
+		return
+	}
+
 	if r.Method == "POST" {
 		r.ParseForm()
 
+		before := p.snapshotConfig()
 		updatedRecord := *record
 		updatedRecord.Proxied = r.FormValue("proxied") == "true"
 		updatedRecord.Notes = strings.TrimSpace(r.FormValue("notes"))
 
-		if err := p.config.UpdateRecord(recordName, updatedRecord); err != nil {
+		if err := p.config.UpdateRecord(recordName, recordType, updatedRecord); err != nil {
 			http.Error(w, "Failed to update record: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -405,18 +526,20 @@ func (p *DDNSPilot) handleEditRecord(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		p.auditRequest(r, auditRecordEdit, recordName, "", before)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 		return
 	}
 
 	data := struct {
+		page
 		Record DDNSRecord
 	}{
 		Record: *record,
 	}
 
-	renderTemplate(w, "edit-record.html", data)
+	p.renderTemplate(w, r, "edit-record.html", &data)
 }
 
 func (p *DDNSPilot) handleRemoveRecord(w http.ResponseWriter, r *http.Request) {
@@ -425,10 +548,16 @@ func (p *DDNSPilot) handleRemoveRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if refuseIfEnvLocked(w, p.config, "records") {
+		return
+	}
+
 	r.ParseForm()
 	recordName := r.FormValue("record_name")
+	recordType := r.FormValue("record_type")
 
-	if err := p.config.RemoveRecord(recordName); err != nil {
+	before := p.snapshotConfig()
+	if err := p.config.RemoveRecord(recordName, recordType); err != nil {
 		http.Error(w, "Failed to remove record: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -437,8 +566,9 @@ func (p *DDNSPilot) handleRemoveRecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p.auditRequest(r, auditRecordRemove, recordName, "", before)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 }
 
 func (p *DDNSPilot) handleToggleRecord(w http.ResponseWriter, r *http.Request) {
@@ -447,19 +577,25 @@ func (p *DDNSPilot) handleToggleRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if refuseIfEnvLocked(w, p.config, "records") {
+		return
+	}
+
 	r.ParseForm()
 	recordName := r.FormValue("record_name")
+	recordType := r.FormValue("record_type")
 
-	record, err := p.config.GetRecord(recordName)
+	record, err := p.config.GetRecord(recordName, recordType)
 	if err != nil {
 		http.Error(w, "Record not found", http.StatusNotFound)
 		return
 	}
 
 	// Toggle enabled status
+	before := p.snapshotConfig()
 	record.Enabled = !record.Enabled
 
-	if err := p.config.UpdateRecord(recordName, *record); err != nil {
+	if err := p.config.UpdateRecord(recordName, recordType, *record); err != nil {
 		http.Error(w, "Failed to update record: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -468,8 +604,9 @@ func (p *DDNSPilot) handleToggleRecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p.auditRequest(r, auditRecordToggle, recordName, "", before)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 }
 
 func (p *DDNSPilot) handleUpdateRecords(w http.ResponseWriter, r *http.Request) {
@@ -478,15 +615,28 @@ func (p *DDNSPilot) handleUpdateRecords(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	results := p.ddns.UpdateAllRecords()
+	results, ran := p.ddns.UpdateAllRecords(p.ctx)
+	if !ran {
+		slog.Info("Manual update skipped, another update is already running")
+		if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Accept") == "application/json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "busy",
+				"message": updateRunningMessage,
+			})
+			return
+		}
+		http.Redirect(w, r, p.config.URL("/?update_result=busy"), http.StatusSeeOther)
+		return
+	}
 
 	// Log all results
-	log.Printf("Update all records completed: %d results", len(results))
+	slog.Info("Update of all records completed", "results", len(results))
 	for _, result := range results {
 		if result.Success {
-			log.Printf("✅ %s: %s (IP: %s)", result.RecordName, result.Message, result.NewIP)
+			slog.Info("Manual update finished", "record", result.RecordName, "new_ip", result.NewIP, "result", result.Message)
 		} else {
-			log.Printf("❌ %s: %s", result.RecordName, result.Message)
+			slog.Error("Manual update failed", "record", result.RecordName, "error", result.Message)
 		}
 	}
 
@@ -513,9 +663,9 @@ func (p *DDNSPilot) handleUpdateRecords(w http.ResponseWriter, r *http.Request)
 	}
 
 	if errorCount > 0 {
-		http.Redirect(w, r, fmt.Sprintf("/?update_result=mixed&success=%d&errors=%d", successCount, errorCount), http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=mixed&success=%d&errors=%d", successCount, errorCount)), http.StatusSeeOther)
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("/?update_result=success&updated=%d", successCount), http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=success&updated=%d", successCount)), http.StatusSeeOther)
 	}
 }
 
@@ -527,14 +677,15 @@ func (p *DDNSPilot) handleUpdateSingle(w http.ResponseWriter, r *http.Request) {
 
 	r.ParseForm()
 	recordName := r.FormValue("record_name")
+	recordType := r.FormValue("record_type")
 
-	result := p.ddns.AutoUpdateRecord(recordName)
+	result := p.ddns.AutoUpdateRecord(p.ctx, recordName, recordType)
 
 	// Log the result
 	if result.Success {
-		log.Printf("✅ Single update %s: %s (IP: %s)", result.RecordName, result.Message, result.NewIP)
+		slog.Info("Manual update finished", "record", result.RecordName, "new_ip", result.NewIP, "result", result.Message)
 	} else {
-		log.Printf("❌ Single update %s: %s", result.RecordName, result.Message)
+		slog.Error("Manual update failed", "record", result.RecordName, "error", result.Message)
 	}
 
 	// For AJAX requests, return JSON response
@@ -546,16 +697,281 @@ func (p *DDNSPilot) handleUpdateSingle(w http.ResponseWriter, r *http.Request) {
 
 	// For regular requests, redirect with result information
 	if result.Success {
-		http.Redirect(w, r, fmt.Sprintf("/?update_result=single_success&record=%s&ip=%s", result.RecordName, result.NewIP), http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=single_success&record=%s&ip=%s", result.RecordName, result.NewIP)), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=single_error&record=%s&error=%s", result.RecordName, result.Message)), http.StatusSeeOther)
+	}
+}
+
+func (p *DDNSPilot) handleCheckDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reports := p.ddns.CheckDrift(p.ctx)
+
+	drifted := 0
+	for _, report := range reports {
+		if !report.InSync {
+			drifted++
+		}
+	}
+	slog.Info("Drift check completed", "drifted", drifted, "records", len(reports))
+
+	// For AJAX requests, return JSON response
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"reports": reports,
+		})
+		return
+	}
+
+	http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=drift&drifted=%d", drifted)), http.StatusSeeOther)
+}
+
+func (p *DDNSPilot) handleFixDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	recordName := r.FormValue("record_name")
+	recordType := r.FormValue("record_type")
+
+	before := p.snapshotConfig()
+	result := p.ddns.FixDrift(p.ctx, recordName, recordType)
+	if result.Success {
+		p.auditRequest(r, auditDriftFix, recordName, result.Message, before)
+	}
+
+	if result.Success {
+		slog.Info("Drift fix finished", "record", result.RecordName, "new_ip", result.NewIP, "result", result.Message)
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("/?update_result=single_error&record=%s&error=%s", result.RecordName, result.Message), http.StatusSeeOther)
+		slog.Error("Drift fix failed", "record", result.RecordName, "error", result.Message)
+	}
+
+	// For AJAX requests, return JSON response
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if result.Success {
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=single_success&record=%s&ip=%s", url.QueryEscape(result.RecordName), url.QueryEscape(result.NewIP))), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, p.config.URL(fmt.Sprintf("/?update_result=single_error&record=%s&error=%s", url.QueryEscape(result.RecordName), url.QueryEscape(result.Message))), http.StatusSeeOther)
+	}
+}
+
+// historyFilterFrom reads the record, since and limit query parameters
+// logRangeFrom reads the since and limit query parameters shared by the
+// history and audit views
+func logRangeFrom(r *http.Request, defaultLimit int) (since time.Time, limit int, err error) {
+	since, err = parseSince(strings.TrimSpace(r.URL.Query().Get("since")))
+	if err != nil {
+		return since, defaultLimit, err
+	}
+
+	limit = defaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return since, defaultLimit, fmt.Errorf("invalid limit %q", limitStr)
+		}
+	}
+
+	return since, limit, nil
+}
+
+func historyFilterFrom(r *http.Request, defaultLimit int) (HistoryFilter, error) {
+	filter := HistoryFilter{
+		RecordName: strings.TrimSpace(r.URL.Query().Get("record")),
+	}
+
+	var err error
+	filter.Since, filter.Limit, err = logRangeFrom(r, defaultLimit)
+	return filter, err
+}
+
+func (p *DDNSPilot) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := historyFilterFrom(r, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := p.ddns.history.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		page
+		Records []DDNSRecord
+		Entries []HistoryEntry
+		Record  string
+		Since   string
+		Limit   int
+	}{
+		Records: p.config.Records,
+		Entries: entries,
+		Record:  filter.RecordName,
+		Since:   r.URL.Query().Get("since"),
+		Limit:   filter.Limit,
 	}
+
+	p.renderTemplate(w, r, "history.html", &data)
+}
+
+func (p *DDNSPilot) handleHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := historyFilterFrom(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := p.ddns.history.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+	})
+}
+
+// auditFilterFrom reads the action, since and limit query parameters
+func auditFilterFrom(r *http.Request, defaultLimit int) (AuditFilter, error) {
+	filter := AuditFilter{
+		Action: strings.TrimSpace(r.URL.Query().Get("action")),
+	}
+
+	var err error
+	filter.Since, filter.Limit, err = logRangeFrom(r, defaultLimit)
+	return filter, err
+}
+
+func (p *DDNSPilot) handleAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFrom(r, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := p.audit.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		page
+		Entries []AuditEntry
+		Actions []string
+		Action  string
+		Since   string
+		Limit   int
+	}{
+		Entries: entries,
+		Actions: []string{
+			auditLogin, auditLoginFailed, auditLoginBlocked, auditLogout, auditPasswordChange,
+			auditRecordAdd, auditRecordEdit, auditRecordRemove, auditRecordToggle, auditRecordsImport,
+			auditDriftFix, auditSettingsChange, auditConfigImport, auditConfigExport,
+		},
+		Action: filter.Action,
+		Since:  r.URL.Query().Get("since"),
+		Limit:  filter.Limit,
+	}
+
+	p.renderTemplate(w, r, "audit.html", &data)
+}
+
+func (p *DDNSPilot) handleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := auditFilterFrom(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := p.audit.Query(filter)
+	if err != nil {
+		http.Error(w, "Failed to read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ddns-pilot-audit-%s.csv\"", time.Now().Format("20060102-150405")))
+		if err := writeAuditCSV(w, entries); err != nil {
+			slog.Warn("Failed to write audit CSV", "error", err)
+		}
+	case "", "json":
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+		})
+	default:
+		http.Error(w, "Unsupported format (use json or csv)", http.StatusBadRequest)
+	}
+}
+
+// settingsFormFields maps settings form inputs to their config paths
+var settingsFormFields = map[string]string{
+	"update_interval":   "update_interval",
+	"auto_update":       "auto_update",
+	"web_port":          "web.port",
+	"default_api_token": "default_api_token",
+}
+
+// refuseIfEnvLocked rejects a change to a setting controlled by an
+// environment variable, reporting true if it did
+func refuseIfEnvLocked(w http.ResponseWriter, config *AppConfig, path string) bool {
+	variable := config.EnvLocked(path)
+	if variable == "" {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("%s is set by the %s environment variable and cannot be changed here", path, variable), http.StatusForbidden)
+	return true
 }
 
 func (p *DDNSPilot) handleSettings(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		r.ParseForm()
 
+		// Env-locked inputs are disabled, so a submitted value is an edit
+		for field, path := range settingsFormFields {
+			if _, submitted := r.Form[field]; submitted && refuseIfEnvLocked(w, p.config, path) {
+				return
+			}
+		}
+
+		before := p.snapshotConfig()
+
 		// Parse update interval
 		if intervalStr := r.FormValue("update_interval"); intervalStr != "" {
 			if interval, err := strconv.Atoi(intervalStr); err == nil && interval >= 1 && interval <= 1440 {
@@ -563,8 +979,10 @@ func (p *DDNSPilot) handleSettings(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Parse auto-update setting
-		p.config.AutoUpdate = r.FormValue("auto_update") == "true"
+		// Parse auto-update setting (an unchecked box is not submitted)
+		if p.config.EnvLocked("auto_update") == "" {
+			p.config.AutoUpdate = r.FormValue("auto_update") == "true"
+		}
 
 		// Parse web port
 		if portStr := r.FormValue("web_port"); portStr != "" {
@@ -574,34 +992,82 @@ func (p *DDNSPilot) handleSettings(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Parse default API token
-		if defaultToken := strings.TrimSpace(r.FormValue("default_api_token")); defaultToken != "" {
-			p.config.DefaultAPIToken = defaultToken
-		} else {
-			// Allow clearing the default token
-			p.config.DefaultAPIToken = ""
+		if p.config.EnvLocked("default_api_token") == "" {
+			if defaultToken := strings.TrimSpace(r.FormValue("default_api_token")); defaultToken != "" {
+				p.config.DefaultAPIToken = defaultToken
+			} else {
+				// Allow clearing the default token
+				p.config.DefaultAPIToken = ""
+			}
 		}
 
 		if err := p.config.save(); err != nil {
 			http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		p.auditRequest(r, auditSettingsChange, "", "", before)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, p.config.URL("/"), http.StatusSeeOther)
 		return
 	}
 
 	data := struct {
-		Config *AppConfig
+		page
+		Config        *AppConfig
+		TestMessage   string
+		TestSucceeded bool
 	}{
 		Config: p.config,
 	}
 
-	renderTemplate(w, "settings.html", data)
+	if notifier := r.URL.Query().Get("tested"); notifier != "" {
+		if testErr := r.URL.Query().Get("error"); testErr != "" {
+			data.TestMessage = fmt.Sprintf("❌ Test notification via %s failed: %s", notifier, testErr)
+		} else {
+			data.TestMessage = fmt.Sprintf("✅ Test notification sent via %s", notifier)
+			data.TestSucceeded = true
+		}
+	}
+
+	p.renderTemplate(w, r, "settings.html", &data)
+}
+
+func (p *DDNSPilot) handleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	name := r.FormValue("name")
+
+	err := p.ddns.notifications.SendTest(name)
+	if err != nil {
+		slog.Warn("Test notification failed", "notifier", name, "error", err)
+	}
+
+	// For AJAX requests, return JSON response
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		response := map[string]interface{}{"status": "success", "notifier": name}
+		if err != nil {
+			response["status"] = "error"
+			response["error"] = err.Error()
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	redirect := "/settings?tested=" + url.QueryEscape(name)
+	if err != nil {
+		redirect += "&error=" + url.QueryEscape(err.Error())
+	}
+	http.Redirect(w, r, p.config.URL(redirect), http.StatusSeeOther)
 }
 
 func (p *DDNSPilot) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
 	// Get current public IP
-	currentIP, _ := p.ddns.GetPublicIP()
+	currentIP, _ := p.ddns.GetPublicIP(r.Context())
 
 	stats := map[string]interface{}{
 		"current_ip":    currentIP,
@@ -646,3 +1112,97 @@ func (p *DDNSPilot) handleAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+func (p *DDNSPilot) handleConfigExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	redact, _ := strconv.ParseBool(r.URL.Query().Get("redact"))
+
+	// The passphrase travels in a header so it never ends up in access logs
+	data, err := exportConfig(p.config, ExportOptions{
+		Format:     format,
+		Redact:     redact,
+		Passphrase: r.Header.Get("X-Bundle-Passphrase"),
+	})
+	if err != nil {
+		http.Error(w, "Failed to export config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.auditRequest(r, auditConfigExport, "", fmt.Sprintf("format=%s redacted=%t encrypted=%t", format, redact, r.Header.Get("X-Bundle-Passphrase") != ""), nil)
+
+	contentType := "application/json"
+	if format == "yaml" || format == "yml" {
+		contentType = "application/yaml"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ddns-pilot-%s.%s\"", time.Now().Format("20060102-150405"), format))
+	w.Write(data)
+}
+
+func (p *DDNSPilot) handleConfigImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imported, _, err := parseConfigBundle(data, r.Header.Get("X-Bundle-Passphrase"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = importStrategyMerge
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	plan, err := planConfigImport(p.config, imported, strategy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Hooks run shell commands, so they can't be changed over the web
+	if current, err := cloneConfig(p.config); err != nil || !sameHooks(current, plan.Result) {
+		http.Error(w, "Import changes hook commands - hooks can only be changed in the config file or with --import", http.StatusForbidden)
+		return
+	}
+
+	if !dryRun && len(plan.Changes) > 0 {
+		before := p.snapshotConfig()
+		if err := p.config.replaceWith(plan.Result); err != nil {
+			http.Error(w, "Failed to apply environment overrides: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := p.config.save(); err != nil {
+			http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Config imported", "strategy", plan.Strategy, "changes", len(plan.Changes))
+		p.auditRequest(r, auditConfigImport, "", plan.Strategy+" strategy", before)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"dry_run":  dryRun,
+		"strategy": plan.Strategy,
+		"changes":  plan.Changes,
+		"warnings": plan.Warnings,
+	})
+}
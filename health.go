@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthConfig sets the thresholds /readyz checks against
+type HealthConfig struct {
+	StaleAfter      int  `json:"stale_after"`       // Minutes without an update cycle before not ready; 0 means 3x update_interval
+	CheckIPSource   bool `json:"check_ip_source"`   // Require the public IP source to answer
+	IPCheckInterval int  `json:"ip_check_interval"` // Seconds a successful IP lookup counts as fresh
+}
+
+// HealthMonitor tracks the scheduler for the readiness check
+type HealthMonitor struct {
+	started time.Time
+
+	mutex            sync.Mutex
+	schedulerStarted time.Time
+	lastCycle        time.Time
+}
+
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{started: time.Now()}
+}
+
+// SchedulerStarted marks the auto-update routine as running
+func (h *HealthMonitor) SchedulerStarted() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.schedulerStarted = time.Now()
+}
+
+// CycleCompleted marks the end of an auto-update cycle
+func (h *HealthMonitor) CycleCompleted() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastCycle = time.Now()
+}
+
+func (h *HealthMonitor) scheduler() (started, lastCycle time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.schedulerStarted, h.lastCycle
+}
+
+// healthCheck is one line of the readiness report
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// handleHealthz reports that the process is alive and serving
+func (p *DDNSPilot) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"uptime": time.Since(p.health.started).Round(time.Second).String(),
+	})
+}
+
+// handleReadyz reports whether DDNS Pilot is doing its job: config loaded,
+// scheduler running and recent, and the public IP source reachable
+func (p *DDNSPilot) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{
+		"config":    p.checkConfigLoaded(),
+		"scheduler": p.checkScheduler(),
+		"ip_source": p.checkIPSource(r.Context()),
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealthJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (p *DDNSPilot) checkConfigLoaded() healthCheck {
+	enabled := 0
+	for _, record := range p.config.Records {
+		if record.Enabled {
+			enabled++
+		}
+	}
+	return healthCheck{OK: true, Detail: fmt.Sprintf("%d record(s), %d enabled", len(p.config.Records), enabled)}
+}
+
+func (p *DDNSPilot) checkScheduler() healthCheck {
+	if !p.config.AutoUpdate {
+		return healthCheck{OK: true, Detail: "auto-update disabled"}
+	}
+
+	started, lastCycle := p.health.scheduler()
+	if started.IsZero() {
+		return healthCheck{OK: false, Detail: "auto-update is enabled but the scheduler is not running (restart to apply)"}
+	}
+
+	staleAfter := time.Duration(p.config.Health.StaleAfter) * time.Minute
+	if staleAfter == 0 {
+		staleAfter = 3 * time.Duration(p.config.UpdateInterval) * time.Minute
+	}
+
+	// Until the first cycle ends, measure from when the scheduler started
+	since := lastCycle
+	if since.IsZero() {
+		since = started
+	}
+	age := time.Since(since).Round(time.Second)
+	if age > staleAfter {
+		return healthCheck{OK: false, Detail: fmt.Sprintf("last cycle %s ago (stale after %s)", age, staleAfter)}
+	}
+	if lastCycle.IsZero() {
+		return healthCheck{OK: true, Detail: fmt.Sprintf("running for %s, first cycle pending", age)}
+	}
+	return healthCheck{OK: true, Detail: fmt.Sprintf("last cycle %s ago", age)}
+}
+
+func (p *DDNSPilot) checkIPSource(ctx context.Context) healthCheck {
+	if !p.config.Health.CheckIPSource {
+		return healthCheck{OK: true, Detail: "check disabled"}
+	}
+
+	// Look up the family the records need; A unless every record is AAAA
+	recordType := defaultRecordType
+	if len(p.config.Records) > 0 {
+		recordType = "AAAA"
+		for _, record := range p.config.Records {
+			if record.Type() != "AAAA" {
+				recordType = defaultRecordType
+				break
+			}
+		}
+	}
+
+	maxAge := time.Duration(p.config.Health.IPCheckInterval) * time.Second
+	ip, checkedAt, err := p.ddns.RecentPublicIP(ctx, recordType, maxAge)
+	if err != nil {
+		return healthCheck{OK: false, Detail: fmt.Sprintf("%s lookup failed %s ago: %v", recordType, time.Since(checkedAt).Round(time.Second), err)}
+	}
+	return healthCheck{OK: true, Detail: fmt.Sprintf("%s is %s (checked %s ago)", recordType, ip, time.Since(checkedAt).Round(time.Second))}
+}
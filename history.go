@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// History actions
+const (
+	historyActionUpdate   = "update"
+	historyActionFixDrift = "fix_drift"
+)
+
+// HistoryEntry is one check or change of a record, as stored on disk
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	RecordName string    `json:"record"`
+	RecordType string    `json:"type,omitempty"`
+	Action     string    `json:"action"`
+	Success    bool      `json:"success"`
+	Changed    bool      `json:"changed"`
+	OldIP      string    `json:"old_ip,omitempty"`
+	NewIP      string    `json:"new_ip,omitempty"`
+	Message    string    `json:"message"`
+	Hooks      []HookRun `json:"hooks,omitempty"`
+}
+
+// Status summarizes an entry for display
+func (e HistoryEntry) Status() string {
+	switch {
+	case !e.Success:
+		return "failed"
+	case e.Changed:
+		return "changed"
+	default:
+		return "unchanged"
+	}
+}
+
+// HistoryFilter selects entries from the history
+type HistoryFilter struct {
+	RecordName string
+	RecordType string // Only with RecordName; entries without a type are A
+	Since      time.Time
+	Limit      int
+}
+
+// HistoryStore is an append-only JSON lines file of update results
+type HistoryStore struct {
+	log *jsonLinesLog[HistoryEntry]
+}
+
+// historyPathFor places the history file next to the config file
+func historyPathFor(configPath string) string {
+	return sidecarPath(configPath, ".history.jsonl")
+}
+
+func NewHistoryStore(path string, config *AppConfig) *HistoryStore {
+	return &HistoryStore{log: &jsonLinesLog[HistoryEntry]{
+		name:   "history",
+		path:   path,
+		timeOf: func(e HistoryEntry) time.Time { return e.Time },
+		limits: func() (int, int) { return config.History.RetentionDays, config.History.MaxEntries },
+	}}
+}
+
+// Append adds an entry to the history
+func (h *HistoryStore) Append(entry HistoryEntry) error {
+	return h.log.Append(entry)
+}
+
+// Compact applies the retention limits to the history file
+func (h *HistoryStore) Compact() error {
+	return h.log.Compact()
+}
+
+// Query returns matching entries, newest first
+func (h *HistoryStore) Query(filter HistoryFilter) ([]HistoryEntry, error) {
+	entries, err := h.log.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []HistoryEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if filter.RecordName != "" && !strings.EqualFold(entry.RecordName, filter.RecordName) {
+			continue
+		}
+		if filter.RecordType != "" && recordTypeOrDefault(entry.RecordType) != filter.RecordType {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			continue
+		}
+		matched = append(matched, entry)
+		if filter.Limit > 0 && len(matched) >= filter.Limit {
+			break
+		}
+	}
+
+	return matched, nil
+}
+
+// Timelines returns the most recent entries of every record, oldest first,
+// for the dashboard, by record key
+func (h *HistoryStore) Timelines(perRecord int) (map[string][]HistoryEntry, error) {
+	entries, err := h.Query(HistoryFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	timelines := make(map[string][]HistoryEntry)
+	for _, entry := range entries {
+		key := recordKey(entry.RecordName, entry.RecordType)
+		if len(timelines[key]) < perRecord {
+			timelines[key] = append([]HistoryEntry{entry}, timelines[key]...)
+		}
+	}
+	return timelines, nil
+}
+
+// historyEntryFor describes the outcome of an update or fix
+func historyEntryFor(action string, record *DDNSRecord, result *UpdateResult) HistoryEntry {
+	entry := HistoryEntry{
+		Time:       result.UpdatedAt,
+		RecordName: result.RecordName,
+		Action:     action,
+		Success:    result.Success,
+		Changed:    result.Changed,
+		OldIP:      result.OldIP,
+		NewIP:      result.NewIP,
+		Message:    result.Message,
+		Hooks:      result.Hooks,
+	}
+	if record != nil {
+		entry.RecordType = record.Type()
+	}
+	return entry
+}
+
+// recordHistory stores the outcome of an update or fix
+func (dm *DDNSManager) recordHistory(entry HistoryEntry) {
+	if dm.history == nil {
+		return
+	}
+
+	if err := dm.history.Append(entry); err != nil {
+		slog.Warn("Failed to record history", "record", entry.RecordName, "error", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestHistory returns a history store in a temporary directory
+func newTestHistory(t *testing.T, retentionDays, maxEntries int) *HistoryStore {
+	t.Helper()
+	config := newDefaultConfig()
+	config.History.RetentionDays = retentionDays
+	config.History.MaxEntries = maxEntries
+	return NewHistoryStore(filepath.Join(t.TempDir(), "ddns-pilot.history.jsonl"), config)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	now := time.Now()
+	ages := []int{40, 20, 5, 1, 0}
+
+	tests := []struct {
+		name          string
+		retentionDays int
+		maxEntries    int
+		wantAges      []int
+	}{
+		{"no limits", 0, 0, []int{0, 1, 5, 20, 40}},
+		{"retention days", 30, 0, []int{0, 1, 5, 20}},
+		{"max entries", 0, 2, []int{0, 1}},
+		{"both, days stricter", 7, 4, []int{0, 1, 5}},
+		{"both, count stricter", 30, 2, []int{0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := newTestHistory(t, tt.retentionDays, tt.maxEntries)
+			for _, age := range ages {
+				entry := HistoryEntry{Time: now.AddDate(0, 0, -age), RecordName: fmt.Sprintf("%d.example.com", age), Success: true}
+				if err := history.Append(entry); err != nil {
+					t.Fatalf("append: %v", err)
+				}
+			}
+
+			if err := history.Compact(); err != nil {
+				t.Fatalf("compact: %v", err)
+			}
+
+			entries, err := history.Query(HistoryFilter{})
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			var got []int
+			for _, entry := range entries {
+				var age int
+				fmt.Sscanf(entry.RecordName, "%d.", &age)
+				got = append(got, age)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantAges) {
+				t.Errorf("kept ages %v, want %v", got, tt.wantAges)
+			}
+		})
+	}
+}
+
+// TestHistoryCompactsWhileAppending checks that the limits are applied
+// every jsonLinesCompactEvery appends without an explicit Compact
+func TestHistoryCompactsWhileAppending(t *testing.T) {
+	history := newTestHistory(t, 0, 10)
+	for i := 0; i < jsonLinesCompactEvery+5; i++ {
+		if err := history.Append(HistoryEntry{Time: time.Now(), RecordName: "home.example.com", Message: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	entries, err := history.Query(HistoryFilter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(entries) != 15 {
+		t.Fatalf("%d entries after %d appends, want 15", len(entries), jsonLinesCompactEvery+5)
+	}
+	if first, last := entries[len(entries)-1].Message, entries[0].Message; first != "90" || last != "104" {
+		t.Errorf("kept entries %s to %s, want 90 to 104", first, last)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	history := newTestHistory(t, 0, 0)
+	now := time.Now()
+	for i, name := range []string{"a.example.com", "b.example.com", "a.example.com", "a.example.com"} {
+		if err := history.Append(HistoryEntry{Time: now.Add(time.Duration(i-3) * time.Hour), RecordName: name, Message: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	// A torn line from a crash is skipped
+	file, err := os.OpenFile(history.log.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	file.WriteString(`{"time": "2024-`)
+	file.Close()
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   string
+	}{
+		{"everything, newest first", HistoryFilter{}, "[3 2 1 0]"},
+		{"one record, any case", HistoryFilter{RecordName: "A.Example.com"}, "[3 2 0]"},
+		{"since", HistoryFilter{Since: now.Add(-90 * time.Minute)}, "[3 2]"},
+		{"limit", HistoryFilter{RecordName: "a.example.com", Limit: 2}, "[3 2]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := history.Query(tt.filter)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Message)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("entries %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	if since, err := parseSince(""); err != nil || !since.IsZero() {
+		t.Errorf("empty since = %v, %v; want zero time", since, err)
+	}
+	if since, err := parseSince("2024-05-01T10:00:00Z"); err != nil || !since.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("RFC 3339 since = %v, %v", since, err)
+	}
+	if since, err := parseSince("2024-05-01"); err != nil || !since.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("date since = %v, %v", since, err)
+	}
+	if since, err := parseSince("24h"); err != nil || time.Since(since) < 24*time.Hour || time.Since(since) > 25*time.Hour {
+		t.Errorf("duration since = %v, %v", since, err)
+	}
+	for _, value := range []string{"yesterday", "-24h", "2024-13-01"} {
+		if _, err := parseSince(value); err == nil {
+			t.Errorf("parseSince(%q) accepted", value)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Hook stages
+const (
+	hookPreUpdate  = "pre_update"
+	hookPostUpdate = "post_update"
+)
+
+// Statuses passed to hooks in DDNS_STATUS
+const (
+	hookStatusPending = "pending" // Pre-update: the change is about to be made
+	hookStatusSuccess = "success"
+	hookStatusFailed  = "failed"
+	hookStatusVetoed  = "vetoed"
+)
+
+const (
+	defaultHookTimeout = 30 // seconds
+	hookOutputLimit    = 4096
+)
+
+// HooksConfig lists commands to run around IP changes, either for every
+// record or for one
+type HooksConfig struct {
+	PreUpdate  []HookConfig `json:"pre_update,omitempty"`
+	PostUpdate []HookConfig `json:"post_update,omitempty"`
+}
+
+// HookConfig is one command, run through the shell
+type HookConfig struct {
+	Command string `json:"command"`
+	Timeout int    `json:"timeout,omitempty"` // Seconds; defaults to 30
+	Veto    bool   `json:"veto,omitempty"`    // Pre-update only: a failure cancels the update
+}
+
+// HookRun is the outcome of one hook, kept in the update history
+type HookRun struct {
+	Stage    string `json:"stage"`
+	Command  string `json:"command"`
+	Success  bool   `json:"success"`
+	ExitCode int    `json:"exit_code"`
+	Duration string `json:"duration"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// hookTimeout returns the time a hook may run
+func (h HookConfig) hookTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return time.Duration(h.Timeout) * time.Second
+	}
+	return defaultHookTimeout * time.Second
+}
+
+// hooksFor returns the hooks of a stage that apply to a record: the global
+// ones first, then the record's own
+func (dm *DDNSManager) hooksFor(stage string, record *DDNSRecord) []HookConfig {
+	pick := func(hooks *HooksConfig) []HookConfig {
+		if hooks == nil {
+			return nil
+		}
+		if stage == hookPreUpdate {
+			return hooks.PreUpdate
+		}
+		return hooks.PostUpdate
+	}
+
+	hooks := append([]HookConfig{}, pick(&dm.config.Hooks)...)
+	return append(hooks, pick(record.Hooks)...)
+}
+
+// runHooks runs the hooks of a stage in order and records them on the
+// result. It returns the failure of the first vetoing hook, which stops
+// the remaining hooks.
+func (dm *DDNSManager) runHooks(ctx context.Context, stage string, record *DDNSRecord, result *UpdateResult, status string) error {
+	env := append(os.Environ(),
+		"DDNS_HOOK="+stage,
+		"DDNS_RECORD="+record.RecordName,
+		"DDNS_RECORD_TYPE="+record.Type(),
+		"DDNS_OLD_IP="+result.OldIP,
+		"DDNS_NEW_IP="+result.NewIP,
+		"DDNS_STATUS="+status,
+	)
+
+	for _, hook := range dm.hooksFor(stage, record) {
+		run := runHook(ctx, stage, hook, env)
+		result.Hooks = append(result.Hooks, run)
+
+		if run.Success {
+			slog.Info("Hook succeeded", "stage", stage, "record", record.RecordName, "command", hook.Command, "duration", run.Duration)
+			continue
+		}
+		slog.Warn("Hook failed", "stage", stage, "record", record.RecordName, "command", hook.Command, "duration", run.Duration, "error", run.Error)
+		if stage == hookPreUpdate && hook.Veto {
+			return fmt.Errorf("%q: %s", hook.Command, run.Error)
+		}
+	}
+	return nil
+}
+
+// runHook executes one command with a timeout, capturing its output
+func runHook(ctx context.Context, stage string, hook HookConfig, env []string) HookRun {
+	run := HookRun{Stage: stage, Command: hook.Command}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.hookTimeout())
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", hook.Command)
+	}
+	cmd.Env = env
+	// Don't wait forever on children that keep the output open
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &output, limit: hookOutputLimit}
+	cmd.Stderr = cmd.Stdout
+
+	start := time.Now()
+	err := cmd.Run()
+	run.Duration = time.Since(start).Round(time.Millisecond).String()
+	run.Output = strings.TrimSpace(output.String())
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		run.ExitCode = -1
+		run.Error = fmt.Sprintf("timed out after %s", hook.hookTimeout())
+	case errors.As(err, &exitErr):
+		run.ExitCode = exitErr.ExitCode()
+		run.Error = fmt.Sprintf("exit status %d", run.ExitCode)
+	case err != nil:
+		run.ExitCode = -1
+		run.Error = err.Error()
+	default:
+		run.Success = true
+	}
+	return run
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty hook cannot bloat the history
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+			b.buf.WriteString("\n[output truncated]")
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// sameHooks reports whether two configs run the same hook commands
+func sameHooks(a, b *AppConfig) bool {
+	hooksOf := func(config *AppConfig) string {
+		all := map[string]interface{}{"": config.Hooks}
+		for _, record := range config.Records {
+			if record.Hooks != nil {
+				all[strings.ToLower(record.Key())] = record.Hooks
+			}
+		}
+		data, _ := json.Marshal(all)
+		return string(data)
+	}
+	return hooksOf(a) == hooksOf(b)
+}
+
+// validateHooks checks the hooks of the config or of one record
+func validateHooks(path string, hooks *HooksConfig, errs *ValidationErrors) {
+	if hooks == nil {
+		return
+	}
+	check := func(stage string, list []HookConfig) {
+		for i, hook := range list {
+			hookPath := fmt.Sprintf("%s.%s[%d]", path, stage, i)
+			if strings.TrimSpace(hook.Command) == "" {
+				errs.add(hookPath+".command", "must not be empty")
+			}
+			if hook.Timeout < 0 {
+				errs.add(hookPath+".timeout", "must not be negative, got %d", hook.Timeout)
+			}
+			if hook.Veto && stage == hookPostUpdate {
+				errs.add(hookPath+".veto", "only pre_update hooks can veto an update")
+			}
+		}
+	}
+	check(hookPreUpdate, hooks.PreUpdate)
+	check(hookPostUpdate, hooks.PostUpdate)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ImportCandidate is a record found in a zone that could be managed
+type ImportCandidate struct {
+	Record  CloudFlareRecord
+	Managed bool // A record with the same name and type is already configured
+}
+
+// ZoneImport holds the records listed for a zone import
+type ZoneImport struct {
+	ZoneName   string
+	ZoneID     string
+	APIToken   string
+	Candidates []ImportCandidate
+}
+
+// ImportSummary reports which records an import added or skipped
+type ImportSummary struct {
+	Imported []string
+	Skipped  []string
+}
+
+// ListImportCandidates looks up a zone and lists its A/AAAA records,
+// flagging the ones already managed
+func (dm *DDNSManager) ListImportCandidates(ctx context.Context, apiToken, zoneName string) (*ZoneImport, error) {
+	zoneName = strings.TrimSuffix(strings.TrimSpace(zoneName), ".")
+	if zoneName == "" {
+		return nil, fmt.Errorf("zone name cannot be empty")
+	}
+	if apiToken == "" {
+		return nil, fmt.Errorf("API token cannot be empty")
+	}
+
+	zoneID, err := dm.GetZoneID(ctx, apiToken, zoneName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone ID: %v", err)
+	}
+
+	records, err := dm.ListZoneRecords(ctx, apiToken, zoneID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %v", err)
+	}
+
+	zoneImport := &ZoneImport{
+		ZoneName: zoneName,
+		ZoneID:   zoneID,
+		APIToken: apiToken,
+	}
+	for _, record := range records {
+		_, err := dm.config.GetRecord(record.Name, record.Type)
+		zoneImport.Candidates = append(zoneImport.Candidates, ImportCandidate{
+			Record:  record,
+			Managed: err == nil,
+		})
+	}
+
+	return zoneImport, nil
+}
+
+// ImportRecords adds the selected CloudFlare records (by record ID) to the
+// config, all sharing the import's API token. Records whose name and type
+// are already managed are skipped; the A and AAAA records of a dual-stack
+// name are imported as two records.
+func (c *AppConfig) ImportRecords(zoneImport *ZoneImport, recordIDs []string) ImportSummary {
+	selected := make(map[string]bool, len(recordIDs))
+	for _, id := range recordIDs {
+		selected[id] = true
+	}
+
+	var summary ImportSummary
+	for _, candidate := range zoneImport.Candidates {
+		record := candidate.Record
+		if !selected[record.ID] {
+			continue
+		}
+
+		if _, err := c.GetRecord(record.Name, record.Type); err == nil {
+			summary.Skipped = append(summary.Skipped, fmt.Sprintf("%s (%s)", record.Name, record.Type))
+			continue
+		}
+
+		c.AddRecord(DDNSRecord{
+			APIToken:   zoneImport.APIToken,
+			RecordName: record.Name,
+			RecordType: record.Type,
+			Proxied:    record.Proxied,
+			ZoneID:     zoneImport.ZoneID,
+			RecordID:   record.ID,
+			Notes:      "Imported from zone " + zoneImport.ZoneName,
+		})
+		summary.Imported = append(summary.Imported, record.Name)
+	}
+
+	return summary
+}
+
+// parseSelection turns input like "1,3-5" or "all" into zero-based indexes
+func parseSelection(input string, count int) ([]int, error) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	if input == "all" {
+		indexes := make([]int, count)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+
+	var indexes []int
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last := part, part
+		if from, to, found := strings.Cut(part, "-"); found {
+			first, last = from, to
+		}
+
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection: %s", part)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection: %s", part)
+		}
+		if start < 1 || end > count || start > end {
+			return nil, fmt.Errorf("selection out of range: %s", part)
+		}
+
+		for i := start; i <= end; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonLinesCompactEvery is how many appends pass between retention sweeps
+const jsonLinesCompactEvery = 100
+
+// jsonLinesLog is an append-only JSON lines file pruned by age and count.
+// It backs the update history and the audit log.
+type jsonLinesLog[T any] struct {
+	name   string
+	path   string
+	timeOf func(T) time.Time
+	limits func() (retentionDays, maxEntries int)
+
+	mutex    sync.Mutex
+	appended int
+}
+
+// sidecarPath places a file next to the config, e.g. ddns-pilot.history.jsonl
+func sidecarPath(configPath, suffix string) string {
+	return strings.TrimSuffix(configPath, filepath.Ext(configPath)) + suffix
+}
+
+// Append adds an entry, pruning old entries every so often
+func (l *jsonLinesLog[T]) Append(entry T) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s entry: %v", l.name, err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s file: %v", l.name, err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s entry: %v", l.name, err)
+	}
+
+	l.appended++
+	if l.appended >= jsonLinesCompactEvery {
+		l.appended = 0
+		if err := l.compactLocked(); err != nil {
+			slog.Warn("Failed to prune log", "log", l.name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadAll returns every entry, oldest first
+func (l *jsonLinesLog[T]) ReadAll() ([]T, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.readLocked()
+}
+
+// Compact applies the retention limits to the file
+func (l *jsonLinesLog[T]) Compact() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.compactLocked()
+}
+
+func (l *jsonLinesLog[T]) compactLocked() error {
+	entries, err := l.readLocked()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	retentionDays, maxEntries := l.limits()
+
+	kept := entries
+	if retentionDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -retentionDays)
+		kept = kept[:0:0]
+		for _, entry := range entries {
+			if !l.timeOf(entry).Before(cutoff) {
+				kept = append(kept, entry)
+			}
+		}
+	}
+	if maxEntries > 0 && len(kept) > maxEntries {
+		kept = kept[len(kept)-maxEntries:]
+	}
+
+	if len(kept) == len(entries) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range kept {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	// Replace the file atomically so a crash never truncates it
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write %s file: %v", l.name, err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("failed to replace %s file: %v", l.name, err)
+	}
+
+	slog.Info("Pruned log entries", "log", l.name, "count", len(entries)-len(kept))
+	return nil
+}
+
+func (l *jsonLinesLog[T]) readLocked() ([]T, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s file: %v", l.name, err)
+	}
+	defer file.Close()
+
+	var entries []T
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry T
+		// A torn last line from a crash is skipped rather than fatal
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s file: %v", l.name, err)
+	}
+
+	return entries, nil
+}
+
+// parseSince accepts an RFC 3339 time, a date or a duration back from now
+// (e.g. "24h")
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q (use RFC 3339, YYYY-MM-DD or a duration like 24h)", value)
+}
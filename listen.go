@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixSocketPrefix marks a listen address as a Unix domain socket path
+const unixSocketPrefix = "unix:"
+
+// WebListenAddresses returns the addresses the web interface listens on:
+// web.listen if set, otherwise every interface on web.port
+func (c *AppConfig) WebListenAddresses() []string {
+	if len(c.Web.Listen) > 0 {
+		return c.Web.Listen
+	}
+	return []string{":" + strconv.Itoa(c.Web.Port)}
+}
+
+// WebPort returns the port of the first TCP listen address, which links
+// such as the HTTPS redirect point to
+func (c *AppConfig) WebPort() int {
+	for _, address := range c.WebListenAddresses() {
+		if strings.HasPrefix(address, unixSocketPrefix) {
+			continue
+		}
+		if _, portStr, err := net.SplitHostPort(address); err == nil {
+			if port, err := strconv.Atoi(portStr); err == nil {
+				return port
+			}
+		}
+	}
+	return c.Web.Port
+}
+
+// validateListen checks web.listen and web.socket_mode
+func validateListen(c *AppConfig, errs *ValidationErrors) {
+	seen := make(map[string]bool)
+	for i, address := range c.Web.Listen {
+		path := fmt.Sprintf("$.web.listen[%d]", i)
+		if seen[address] {
+			errs.add(path, "duplicate address %q", address)
+		}
+		seen[address] = true
+
+		if socket, ok := strings.CutPrefix(address, unixSocketPrefix); ok {
+			if socket == "" {
+				errs.add(path, "must name a socket path after unix:")
+			}
+			continue
+		}
+
+		_, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			errs.add(path, "must be host:port, [ipv6]:port or unix:/path, got %q", address)
+			continue
+		}
+		if port, err := strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+			errs.add(path, "port must be between 1 and 65535, got %q", portStr)
+		}
+	}
+
+	if _, err := parseSocketMode(c.Web.SocketMode); err != nil {
+		errs.add("$.web.socket_mode", "%v", err)
+	}
+}
+
+// parseSocketMode reads an octal permission such as "0660"
+func parseSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("must be octal permissions such as 0660, got %q", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// openWebListeners binds every listen address up front, so a taken port is
+// reported before anything is served
+func (p *DDNSPilot) openWebListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range p.config.WebListenAddresses() {
+		listener, err := listenOn(address, p.config.Web.SocketMode)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func listenOn(address, socketMode string) (net.Listener, error) {
+	socket, ok := strings.CutPrefix(address, unixSocketPrefix)
+	if !ok {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+		}
+		return listener, nil
+	}
+
+	// A socket left behind by an unclean exit blocks the bind; anything
+	// else at that path is left alone
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+	if perm, _ := parseSocketMode(socketMode); perm != 0 {
+		if err := os.Chmod(socket, perm); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set permissions on %s: %v", socket, err)
+		}
+	}
+	return listener, nil
+}
+
+// listenURL is the address to open in a browser for a TCP listener
+func listenURL(scheme string, listener net.Listener) string {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	host := "localhost"
+	if !addr.IP.IsUnspecified() && !addr.IP.IsLoopback() {
+		host = addr.IP.String()
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// serveWeb serves the web interface on every listener until the server is
+// shut down. A listener that fails is logged; the others keep serving.
+func serveWeb(server *http.Server, listeners []net.Listener) {
+	// Decided up front: Serve fills in TLSConfig for HTTP/2 as it starts
+	useTLS := server.TLSConfig != nil
+
+	done := make(chan struct{}, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			defer func() { done <- struct{}{} }()
+
+			var err error
+			if useTLS {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				slog.Error("Web listener failed", "address", listener.Addr().String(), "error", err)
+			}
+		}(listener)
+	}
+
+	for range listeners {
+		<-done
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Log formats selectable with --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// minSecretLength keeps short values such as "admin" from being scrubbed
+// out of every log line that happens to contain them
+const minSecretLength = 8
+
+// Log outputs, kept so the config can add more once it is loaded
+var (
+	logLevel   slog.Level
+	logFormat  string
+	logConsole slog.Handler
+	logFile    *rotatingFile
+)
+
+// setupLogging installs the default slog logger. Everything logged, including
+// through the standard log package, goes through the redacting handler to
+// the console and the in-memory log viewer.
+func setupLogging(level, format string, w io.Writer) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q (use debug, info, warn or error)", level)
+	}
+
+	console, err := newFormatHandler(format, w)
+	if err != nil {
+		return err
+	}
+	logFormat, logConsole = format, console
+
+	installLogHandlers(console, &captureHandler{buffer: logBuffer, level: logLevel})
+	return nil
+}
+
+// attachLogOutputs applies the logs config: the size of the viewer's buffer
+// and the optional rotating log file
+func attachLogOutputs(cfg LogsConfig) error {
+	handlers := []slog.Handler{logConsole}
+
+	logBuffer.Resize(cfg.BufferSize)
+	if cfg.BufferSize > 0 {
+		handlers = append(handlers, &captureHandler{buffer: logBuffer, level: logLevel})
+	}
+
+	if cfg.File != "" {
+		file, err := openRotatingFile(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.MaxFiles)
+		if err != nil {
+			return err
+		}
+		handler, err := newFormatHandler(logFormat, file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		logFile = file
+		handlers = append(handlers, handler)
+	}
+
+	installLogHandlers(handlers...)
+	return nil
+}
+
+func newFormatHandler(format string, w io.Writer) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch strings.ToLower(format) {
+	case logFormatText, "":
+		return slog.NewTextHandler(w, opts), nil
+	case logFormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (use text or json)", format)
+	}
+}
+
+func installLogHandlers(handlers ...slog.Handler) {
+	slog.SetDefault(slog.New(&redactingHandler{inner: fanoutHandler(handlers)}))
+}
+
+// fanoutHandler passes each record to several handlers
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// rotatingFile is a log file that is renamed to <path>.1 (and older files
+// shifted up to <path>.<maxFiles>) once it grows past maxSize
+type rotatingFile struct {
+	mutex    sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the console; the next write tries again
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	if f.maxFiles > 0 {
+		for i := f.maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+// logSecrets holds every secret value from the config, so they can be
+// scrubbed from messages and errors that embed them (e.g. a Telegram URL
+// in a request error)
+var logSecrets = &secretSet{}
+
+type secretSet struct {
+	mutex    sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// Learn adds the config's secrets. Old values stay known, so a rotated
+// token is still hidden in lines logged after the change.
+func (s *secretSet) Learn(config *AppConfig) {
+	tree, err := toGenericTree(config)
+	if err != nil {
+		return
+	}
+
+	var found []string
+	collectSecrets("", tree, &found)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]bool)
+	}
+	changed := false
+	for _, value := range found {
+		if len(value) >= minSecretLength && !s.values[value] {
+			s.values[value] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// Longest first, so a secret containing another is replaced whole
+	all := make([]string, 0, len(s.values))
+	for value := range s.values {
+		all = append(all, value)
+	}
+	sort.Slice(all, func(i, j int) bool { return len(all[i]) > len(all[j]) })
+	pairs := make([]string, 0, 2*len(all))
+	for _, value := range all {
+		pairs = append(pairs, value, redactedValue)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+// Scrub replaces known secrets in a string
+func (s *secretSet) Scrub(text string) string {
+	s.mutex.RLock()
+	replacer := s.replacer
+	s.mutex.RUnlock()
+	if replacer == nil {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+func collectSecrets(parent string, value interface{}, found *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if s, ok := item.(string); ok && isSecretField(parent, k) {
+				*found = append(*found, s)
+				continue
+			}
+			collectSecrets(k, item, found)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectSecrets(parent, item, found)
+		}
+	}
+}
+
+// redactingHandler scrubs known secrets from the message and from every
+// string-like attribute before passing the record on
+type redactingHandler struct {
+	inner slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	scrubbed := slog.NewRecord(r.Time, r.Level, logSecrets.Scrub(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		scrubbed.AddAttrs(scrubAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, scrubbed)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		scrubbed[i] = scrubAttr(a)
+	}
+	return &redactingHandler{inner: h.inner.WithAttrs(scrubbed)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{inner: h.inner.WithGroup(name)}
+}
+
+// scrubAttr hides attributes whose key names a secret, such as api_token or
+// password, and known secrets inside any other value
+func scrubAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+	if isSecretField("", a.Key) && value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, logSecrets.Scrub(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		scrubbed := make([]any, len(group))
+		for i, member := range group {
+			scrubbed[i] = scrubAttr(member)
+		}
+		return slog.Group(a.Key, scrubbed...)
+	case slog.KindAny:
+		// Errors and other values are logged through their text form
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, logSecrets.Scrub(err.Error()))
+		}
+		if s, ok := value.Any().(fmt.Stringer); ok {
+			return slog.String(a.Key, logSecrets.Scrub(s.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
+
+// logDefaultFromEnv returns the default for --log-level or --log-format
+func logDefaultFromEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
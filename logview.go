@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultLogBufferSize = 1000
+
+// LogsConfig controls the in-memory log viewer and the optional log file
+type LogsConfig struct {
+	BufferSize int    `json:"buffer_size"`    // Entries kept in memory for /logs; 0 turns the viewer off
+	File       string `json:"file,omitempty"` // Also write logs to this file, rotated by size
+	MaxSizeMB  int    `json:"max_size_mb"`    // Rotate the file once it reaches this size
+	MaxFiles   int    `json:"max_files"`      // Rotated files to keep, as <file>.1 to <file>.N
+}
+
+// LogEntry is one captured log line
+type LogEntry struct {
+	ID      int64             `json:"id"`
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Record  string            `json:"record,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+
+	level slog.Level
+}
+
+// LevelClass names the entry's level for CSS
+func (e LogEntry) LevelClass() string {
+	return strings.ToLower(e.Level)
+}
+
+// LogFilter selects entries from the log buffer
+type LogFilter struct {
+	MinLevel   slog.Level
+	RecordName string
+	After      int64 // Only entries with a larger ID
+	Limit      int   // The most recent Limit matches; 0 means all
+}
+
+// Matches reports whether an entry passes the filter
+func (f LogFilter) Matches(entry LogEntry) bool {
+	if entry.level < f.MinLevel || entry.ID <= f.After {
+		return false
+	}
+	return f.RecordName == "" || strings.EqualFold(entry.Record, f.RecordName)
+}
+
+// LogBuffer keeps the most recent log entries in a ring
+type LogBuffer struct {
+	mutex   sync.Mutex
+	entries []LogEntry
+	start   int // Index of the oldest entry once the ring is full
+	nextID  int64
+	publish func(LogEntry)
+}
+
+// logBuffer captures everything logged through slog
+var logBuffer = &LogBuffer{entries: make([]LogEntry, 0, defaultLogBufferSize)}
+
+// Resize changes how many entries are kept, dropping the oldest if needed
+func (b *LogBuffer) Resize(size int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	entries := b.ordered()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	b.entries = append(make([]LogEntry, 0, size), entries...)
+	b.start = 0
+}
+
+// SetPublisher streams every new entry, e.g. to the live event stream
+func (b *LogBuffer) SetPublisher(publish func(LogEntry)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.publish = publish
+}
+
+// Add stores an entry, overwriting the oldest once the buffer is full
+func (b *LogBuffer) Add(entry LogEntry) {
+	b.mutex.Lock()
+	size := cap(b.entries)
+	if size == 0 {
+		b.mutex.Unlock()
+		return
+	}
+	b.nextID++
+	entry.ID = b.nextID
+	if len(b.entries) < size {
+		b.entries = append(b.entries, entry)
+	} else {
+		b.entries[b.start] = entry
+		b.start = (b.start + 1) % size
+	}
+	publish := b.publish
+	b.mutex.Unlock()
+
+	if publish != nil {
+		publish(entry)
+	}
+}
+
+// Query returns matching entries, oldest first
+func (b *LogBuffer) Query(filter LogFilter) []LogEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var matched []LogEntry
+	for _, entry := range b.ordered() {
+		if filter.Matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[len(matched)-filter.Limit:]
+	}
+	return matched
+}
+
+// ordered returns the entries oldest first; the caller holds the mutex
+func (b *LogBuffer) ordered() []LogEntry {
+	return append(append([]LogEntry{}, b.entries[b.start:]...), b.entries[:b.start]...)
+}
+
+// captureHandler is the slog handler that feeds the log buffer
+type captureHandler struct {
+	buffer *LogBuffer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	group  string // Key prefix from WithGroup
+}
+
+func (h *captureHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry := LogEntry{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+		level:   r.Level,
+	}
+
+	add := func(a slog.Attr) bool {
+		h.addField(&entry, h.group, a)
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	h.buffer.Add(entry)
+	return nil
+}
+
+func (h *captureHandler) addField(entry *LogEntry, prefix string, a slog.Attr) {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, member := range value.Group() {
+			h.addField(entry, prefix+a.Key+".", member)
+		}
+		return
+	}
+
+	key := prefix + a.Key
+	if key == "record" {
+		entry.Record = value.String()
+		return
+	}
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]string)
+	}
+	entry.Fields[key] = value.String()
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	if h.group != "" {
+		// Attributes added inside a group carry its prefix
+		for i := len(h.attrs); i < len(clone.attrs); i++ {
+			clone.attrs[i].Key = h.group + clone.attrs[i].Key
+		}
+	}
+	return &clone
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// logFilterFrom reads the level, record, after and limit query parameters
+func logFilterFrom(r *http.Request, defaultLimit int) (LogFilter, error) {
+	filter := LogFilter{
+		MinLevel:   slog.LevelDebug,
+		RecordName: strings.TrimSpace(r.URL.Query().Get("record")),
+		Limit:      defaultLimit,
+	}
+
+	if level := r.URL.Query().Get("level"); level != "" {
+		if err := filter.MinLevel.UnmarshalText([]byte(level)); err != nil {
+			return filter, fmt.Errorf("invalid level %q (use debug, info, warn or error)", level)
+		}
+	}
+	if after := r.URL.Query().Get("after"); after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || id < 0 {
+			return filter, fmt.Errorf("invalid after %q", after)
+		}
+		filter.After = id
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limitStr)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func (p *DDNSPilot) handleLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := logFilterFrom(r, 500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries := logBuffer.Query(filter)
+	var lastID int64
+	if len(entries) > 0 {
+		lastID = entries[len(entries)-1].ID
+	}
+
+	data := struct {
+		page
+		Records    []DDNSRecord
+		Entries    []LogEntry
+		LastID     int64
+		Record     string
+		Level      string
+		Limit      int
+		BufferSize int
+		File       string
+	}{
+		Records:    p.config.Records,
+		Entries:    entries,
+		LastID:     lastID,
+		Record:     filter.RecordName,
+		Level:      strings.ToLower(r.URL.Query().Get("level")),
+		Limit:      filter.Limit,
+		BufferSize: p.config.Logs.BufferSize,
+		File:       p.config.Logs.File,
+	}
+
+	p.renderTemplate(w, r, "logs.html", &data)
+}
+
+func (p *DDNSPilot) handleLogsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := logFilterFrom(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries := logBuffer.Query(filter)
+	if entries == nil {
+		entries = []LogEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+	})
+}
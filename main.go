@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -16,6 +19,13 @@ import (
 type DDNSPilot struct {
 	config *AppConfig
 	ddns   *DDNSManager
+	audit  *AuditLog
+	health *HealthMonitor
+	certs  *certReloader // Web certificate when HTTPS is on
+
+	// Cancelled on shutdown; see shutdownContexts
+	stopping context.Context // Start nothing new
+	ctx      context.Context // Abort in-flight updates
 }
 
 func main() {
@@ -25,34 +35,87 @@ func main() {
 		updateAll   = flag.Bool("update", false, "Update all enabled DNS records")
 		addRecord   = flag.Bool("add", false, "Add a new DNS record interactively")
 		listRecords = flag.Bool("list", false, "List all configured DNS records")
+		checkDrift  = flag.Bool("check", false, "Compare DNS records at CloudFlare against the config")
+		fixDrift    = flag.Bool("fix", false, "With --check, fix any drifted records")
+		sendDigest  = flag.Bool("digest", false, "Send the configured email digests now")
+		importZone  = flag.String("import-zone", "", "Import existing A/AAAA records from a zone")
+		exportFile  = flag.String("export", "", "Export the config as a bundle to a file (- for stdout)")
+		importFile  = flag.String("import", "", "Import a config bundle from a file (- for stdin)")
+		format      = flag.String("format", "", "Export format: json or yaml (default: from file extension)")
+		redact      = flag.Bool("redact", false, "With --export, replace secrets with REDACTED")
+		strategy    = flag.String("strategy", importStrategyMerge, "With --import: replace or merge (by record name)")
+		dryRun      = flag.Bool("dry-run", false, "With --import, only show the changes")
+		passFile    = flag.String("passphrase-file", "", "File holding the bundle encryption passphrase")
+		validate    = flag.Bool("validate", false, "Validate the config file and exit")
+		configFile  = flag.String("config", "", "Config file (.json, .yaml, .yml or .toml)")
+		logLevel    = flag.String("log-level", logDefaultFromEnv("DDNS_PILOT_LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+		logFormat   = flag.String("log-format", logDefaultFromEnv("DDNS_PILOT_LOG_FORMAT", logFormatText), "Log format: text or json")
 		showHelp    = flag.Bool("help", false, "Show help information")
 	)
 	flag.Parse()
 
+	if err := setupLogging(*logLevel, *logFormat, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(2)
+	}
+
 	if *showHelp {
 		showUsage()
 		return
 	}
 
+	configPath = resolveConfigPath(*configFile)
+
+	if *validate {
+		cliValidateConfig()
+		return
+	}
+
 	// Load configuration
 	if err := ensureConfigDir(); err != nil {
-		log.Fatalf("Failed to create config directory: %v", err)
+		slog.Error("Failed to create config directory", "error", err)
+		os.Exit(1)
 	}
 
 	config, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		slog.Error("Failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+	if err := attachLogOutputs(config.Logs); err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		os.Exit(1)
 	}
 
 	// Create DDNS Pilot instance
 	pilot := &DDNSPilot{
 		config: config,
 		ddns:   NewDDNSManager(config),
+		audit:  NewAuditLog(config.AuditPath(), config),
+		health: NewHealthMonitor(),
+
+		stopping: context.Background(),
+		ctx:      context.Background(),
 	}
 
 	// Determine mode
-	if *cliMode || *updateAll || *addRecord || *listRecords {
-		pilot.runCLIMode(*updateAll, *addRecord, *listRecords)
+	if *cliMode || *updateAll || *addRecord || *listRecords || *checkDrift || *sendDigest || *importZone != "" || *exportFile != "" || *importFile != "" {
+		pilot.runCLIMode(cliOptions{
+			updateAll:      *updateAll,
+			addRecord:      *addRecord,
+			listRecords:    *listRecords,
+			checkDrift:     *checkDrift,
+			fixDrift:       *fixDrift,
+			sendDigest:     *sendDigest,
+			importZone:     *importZone,
+			exportFile:     *exportFile,
+			importFile:     *importFile,
+			format:         *format,
+			redact:         *redact,
+			strategy:       *strategy,
+			dryRun:         *dryRun,
+			passphraseFile: *passFile,
+		})
 	} else {
 		// Default: start web mode if no arguments provided
 		fmt.Println("Starting DDNS Pilot in web mode. Use --help for CLI options.")
@@ -61,8 +124,10 @@ func main() {
 }
 
 func (p *DDNSPilot) startWebMode() {
+	p.stopping, p.ctx = shutdownContexts()
+
 	// Initialize templates and static file handling
-	initTemplates()
+	initTemplates(p.config.URL)
 	setupStaticHandler()
 
 	// Start session cleanup routine
@@ -78,75 +143,231 @@ func (p *DDNSPilot) startWebMode() {
 		}
 	}()
 
+	// Apply history and audit retention once at startup; appends prune as
+	// they go
+	if err := p.ddns.history.Compact(); err != nil {
+		slog.Warn("Failed to prune history", "error", err)
+	}
+	if err := p.audit.Compact(); err != nil {
+		slog.Warn("Failed to prune audit log", "error", err)
+	}
+
+	// Tail the log viewer over the live event stream
+	logBuffer.SetPublisher(func(entry LogEntry) {
+		p.ddns.events.Broadcast(streamLog, entry)
+	})
+
+	// Background routines, waited for on shutdown
+	var background sync.WaitGroup
+	runInBackground := func(run func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run()
+		}()
+	}
+
+	// Send daily and weekly email digests
+	runInBackground(func() { p.ddns.notifications.RunDigests(p.stopping) })
+
+	// Publish state over MQTT; the command topic triggers an update
+	runInBackground(func() {
+		p.ddns.mqtt.Run(p.stopping, func() {
+			if _, ran := p.ddns.UpdateAllRecords(p.ctx); !ran {
+				slog.Info("Ignoring MQTT update command, an update is already running")
+			}
+		})
+	})
+
 	// Start auto-update routine if enabled
 	if p.config.AutoUpdate {
-		go p.startAutoUpdateRoutine()
+		runInBackground(p.startAutoUpdateRoutine)
 	}
 
 	// Setup HTTP routes
 	http.HandleFunc("/login", p.handleLogin)
 	http.HandleFunc("/change-password", p.handleChangePassword)
+	http.HandleFunc("/healthz", p.handleHealthz)
+	http.HandleFunc("/readyz", p.handleReadyz)
 	http.HandleFunc("/logout", sessionAuth(p.handleLogout, p.config))
 	http.HandleFunc("/", sessionAuth(p.handleIndex, p.config))
 	http.HandleFunc("/add-record", sessionAuth(p.handleAddRecord, p.config))
+	http.HandleFunc("/import-records", sessionAuth(p.handleImportRecords, p.config))
 	http.HandleFunc("/edit-record", sessionAuth(p.handleEditRecord, p.config))
 	http.HandleFunc("/remove-record", sessionAuth(p.handleRemoveRecord, p.config))
 	http.HandleFunc("/toggle-record", sessionAuth(p.handleToggleRecord, p.config))
 	http.HandleFunc("/update-records", sessionAuth(p.handleUpdateRecords, p.config))
 	http.HandleFunc("/update-single", sessionAuth(p.handleUpdateSingle, p.config))
+	http.HandleFunc("/check-drift", sessionAuth(p.handleCheckDrift, p.config))
+	http.HandleFunc("/fix-drift", sessionAuth(p.handleFixDrift, p.config))
+	http.HandleFunc("/history", sessionAuth(p.handleHistory, p.config))
+	http.HandleFunc("/audit", sessionAuth(p.handleAudit, p.config))
 	http.HandleFunc("/settings", sessionAuth(p.handleSettings, p.config))
+	http.HandleFunc("/notifiers/test", sessionAuth(p.handleTestNotifier, p.config))
 	http.HandleFunc("/api/stats", sessionAuth(p.handleStatsAPI, p.config))
 	http.HandleFunc("/api", sessionAuth(p.handleAPI, p.config))
+	http.HandleFunc("/api/v1/config/export", sessionAuth(p.handleConfigExport, p.config))
+	http.HandleFunc("/api/v1/config/import", sessionAuth(p.handleConfigImport, p.config))
+	http.HandleFunc("/api/v1/history", sessionAuth(p.handleHistoryAPI, p.config))
+	http.HandleFunc("/api/v1/audit", sessionAuth(p.handleAuditAPI, p.config))
+	http.HandleFunc("/api/v1/events", sessionAuth(p.handleEvents, p.config))
+	http.HandleFunc("/logs", sessionAuth(p.handleLogs, p.config))
+	http.HandleFunc("/api/v1/logs", sessionAuth(p.handleLogsAPI, p.config))
+
+	// Obtain and renew the web certificate over ACME
+	if p.config.Web.TLS.ACME.Enabled() {
+		runInBackground(p.runACME)
+	}
 
-	// Determine port to use
-	port := strconv.Itoa(p.config.Web.Port)
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
+	// Plain HTTP only redirects once HTTPS is on
+	if p.config.Web.TLS.Enabled() && p.config.Web.TLS.RedirectPort != 0 {
+		runInBackground(p.startRedirectListener)
 	}
 
-	log.Printf("Starting DDNS Pilot on port %s", port)
-	log.Printf("Access the web interface at: http://localhost:%s", port)
-	log.Printf("Username: admin (password is securely hashed)")
+	// Metrics go on their own listener if one is configured
+	switch {
+	case !p.config.Metrics.Enabled:
+	case p.config.Metrics.Listen != "":
+		runInBackground(p.startMetricsListener)
+	default:
+		http.HandleFunc("/metrics", p.handleMetrics(true))
+	}
+
+	tlsConfig, err := p.webTLSConfig()
+	if err != nil {
+		slog.Error("Failed to set up HTTPS", "error", err)
+		os.Exit(1)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	// PORT and DDNS_PILOT_WEB_PORT are applied with the other overrides
+	listeners, err := p.openWebListeners()
+	if err != nil {
+		slog.Error("Failed to start HTTP server", "error", err)
+		os.Exit(1)
+	}
+	for _, listener := range listeners {
+		if url := listenURL(scheme, listener); url != "" {
+			slog.Info("Listening", "address", listener.Addr().String(), "url", url+p.config.Web.BasePath)
+		} else {
+			slog.Info("Listening", "socket", listener.Addr().String())
+		}
+	}
+	slog.Info("Starting DDNS Pilot", "username", "admin")
 
 	if len(p.config.Records) == 0 {
-		log.Printf("No DNS records configured. Add some via the web interface.")
+		slog.Info("No DNS records configured. Add some via the web interface.")
 	} else {
-		log.Printf("Managing %d DNS record(s)", len(p.config.Records))
+		slog.Info("Managing DNS records", "count", len(p.config.Records))
 	}
 
 	if p.config.AutoUpdate {
-		log.Printf("Auto-update enabled (every %d minutes)", p.config.UpdateInterval)
+		slog.Info("Auto-update enabled", "interval_minutes", p.config.UpdateInterval)
 	} else {
-		log.Printf("Auto-update disabled - manual updates only")
+		slog.Info("Auto-update disabled - manual updates only")
 	}
 
-	// Handle graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	server := &http.Server{
+		Handler:   p.withTrustedProxies(p.withSecurityHeaders(p.withCSRF(p.withBasePath(http.DefaultServeMux)))),
+		TLSConfig: tlsConfig,
+	}
+	// Live event streams never go idle, so end them when shutdown starts
+	server.RegisterOnShutdown(p.ddns.events.Close)
 
+	// On shutdown, stop accepting connections and let running requests,
+	// such as a manual update, finish within the grace period
+	serverStopped := make(chan struct{})
 	go func() {
-		<-c
-		log.Println("Shutting down...")
-		os.Exit(0)
+		defer close(serverStopped)
+		<-p.stopping.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP server did not shut down cleanly", "error", err)
+		}
 	}()
 
 	// Start server
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start HTTP server: %v", err)
-	}
+	serveWeb(server, listeners)
+
+	<-serverStopped
+	background.Wait()
+	p.finishShutdown()
 }
 
-func (p *DDNSPilot) runCLIMode(updateAll, addRecord, listRecords bool) {
+// cliOptions holds the command line actions selected for CLI mode
+type cliOptions struct {
+	updateAll   bool
+	addRecord   bool
+	listRecords bool
+	checkDrift  bool
+	fixDrift    bool
+	sendDigest  bool
+	importZone  string
+
+	// Config bundle export/import
+	exportFile     string
+	importFile     string
+	format         string
+	redact         bool
+	strategy       string
+	dryRun         bool
+	passphraseFile string
+}
+
+func (p *DDNSPilot) runCLIMode(opts cliOptions) {
 	switch {
-	case updateAll:
+	case opts.updateAll:
 		p.cliUpdateAll()
-	case addRecord:
+	case opts.addRecord:
 		p.cliAddRecord()
-	case listRecords:
+	case opts.listRecords:
 		p.cliListRecords()
+	case opts.checkDrift:
+		p.cliCheckDrift(opts.fixDrift)
+	case opts.sendDigest:
+		p.cliSendDigests()
+	case opts.importZone != "":
+		p.cliImportZone(opts.importZone)
+	case opts.exportFile != "":
+		p.cliExportConfig(opts)
+	case opts.importFile != "":
+		p.cliImportConfig(opts)
 	default:
 		showUsage()
 	}
+
+	// Let notifications raised by this run go out before exiting
+	p.ddns.notifications.Wait()
+}
+
+func cliValidateConfig() {
+	subject := configPath
+	var err error
+	if _, statErr := os.Stat(configPath); os.IsNotExist(statErr) {
+		fmt.Printf("ℹ️ %s does not exist - defaults will be used\n", configPath)
+		subject = "Environment overrides"
+		err = newDefaultConfig().applyEnvOverrides()
+	} else {
+		err = validateConfigFile(configPath)
+	}
+
+	if err != nil {
+		fmt.Printf("❌ %s is invalid\n", subject)
+		if errs, ok := err.(ValidationErrors); ok {
+			for _, problem := range errs {
+				fmt.Printf("   %s\n", problem)
+			}
+		} else {
+			fmt.Printf("   %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ %s is valid\n", subject)
 }
 
 func (p *DDNSPilot) cliUpdateAll() {
@@ -157,13 +378,21 @@ func (p *DDNSPilot) cliUpdateAll() {
 		return
 	}
 
-	results := p.ddns.UpdateAllRecords()
+	// Ctrl+C aborts the update in progress; the config is still saved
+	ctx, stop := signal.NotifyContext(p.ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results, ran := p.ddns.UpdateAllRecords(ctx)
+	if !ran {
+		fmt.Println("❌ " + updateRunningMessage)
+		return
+	}
 
 	fmt.Printf("\n📊 Update Results:\n")
 	for _, result := range results {
 		if result.Success {
 			fmt.Printf("✅ %s: %s\n", result.RecordName, result.Message)
-			if result.OldIP != result.NewIP && result.NewIP != "" {
+			if result.Changed {
 				fmt.Printf("   %s → %s\n", result.OldIP, result.NewIP)
 			}
 		} else {
@@ -175,6 +404,11 @@ func (p *DDNSPilot) cliUpdateAll() {
 func (p *DDNSPilot) cliAddRecord() {
 	fmt.Println("🔧 Add a new DNS record")
 
+	if variable := p.config.EnvLocked("records"); variable != "" {
+		fmt.Printf("❌ Records are set by the %s environment variable\n", variable)
+		os.Exit(1)
+	}
+
 	var record DDNSRecord
 
 	// Get record name
@@ -182,13 +416,13 @@ func (p *DDNSPilot) cliAddRecord() {
 	fmt.Scanln(&record.RecordName)
 	record.RecordName = strings.TrimSpace(record.RecordName)
 
-	if record.RecordName == "" {
-		fmt.Println("❌ Record name cannot be empty")
+	if err := validateHostname(record.RecordName); err != nil {
+		fmt.Printf("❌ Invalid record name: %v\n", err)
 		return
 	}
 
 	// Check if record already exists
-	if _, err := p.config.GetRecord(record.RecordName); err == nil {
+	if _, err := p.config.GetRecord(record.RecordName, record.Type()); err == nil {
 		fmt.Println("❌ Record already exists")
 		return
 	}
@@ -222,14 +456,14 @@ func (p *DDNSPilot) cliAddRecord() {
 		return
 	}
 
-	zoneID, err := p.ddns.GetZoneID(record.APIToken, zoneName)
+	zoneID, err := p.ddns.GetZoneID(p.ctx, record.APIToken, zoneName)
 	if err != nil {
 		fmt.Printf("❌ Failed to get zone ID: %v\n", err)
 		return
 	}
 	record.ZoneID = zoneID
 
-	recordID, err := p.ddns.GetRecordID(record.APIToken, record.ZoneID, record.RecordName)
+	recordID, err := p.ddns.GetRecordID(p.ctx, record.APIToken, record.ZoneID, record.RecordName, record.Type())
 	if err != nil {
 		fmt.Printf("❌ Failed to get record ID: %v\n", err)
 		return
@@ -237,12 +471,14 @@ func (p *DDNSPilot) cliAddRecord() {
 	record.RecordID = recordID
 
 	// Add the record
+	before := p.snapshotConfig()
 	p.config.AddRecord(record)
 
 	if err := p.config.save(); err != nil {
 		fmt.Printf("❌ Failed to save config: %v\n", err)
 		return
 	}
+	p.auditCLI(auditRecordAdd, record.RecordName, "", before)
 
 	fmt.Println("✅ DNS record added successfully!")
 }
@@ -263,6 +499,7 @@ func (p *DDNSPilot) cliListRecords() {
 
 		fmt.Printf("\n%d. %s\n", i+1, record.RecordName)
 		fmt.Printf("   Status: %s\n", status)
+		fmt.Printf("   Type: %s\n", record.Type())
 		fmt.Printf("   Proxied: %v\n", record.Proxied)
 		fmt.Printf("   Last IP: %s\n", record.LastIP)
 		fmt.Printf("   Last Updated: %s\n", record.LastUpdated)
@@ -272,28 +509,285 @@ func (p *DDNSPilot) cliListRecords() {
 	}
 }
 
+func (p *DDNSPilot) cliImportZone(zoneName string) {
+	fmt.Printf("📥 Import records from zone %s\n", zoneName)
+
+	if variable := p.config.EnvLocked("records"); variable != "" {
+		fmt.Printf("❌ Records are set by the %s environment variable\n", variable)
+		os.Exit(1)
+	}
+
+	apiToken := p.config.DefaultAPIToken
+	if apiToken != "" {
+		fmt.Println("Using the default CloudFlare API token from settings")
+	} else {
+		fmt.Print("CloudFlare API Token: ")
+		fmt.Scanln(&apiToken)
+		apiToken = strings.TrimSpace(apiToken)
+	}
+
+	fmt.Println("🔍 Looking up zone records...")
+
+	zoneImport, err := p.ddns.ListImportCandidates(p.ctx, apiToken, zoneName)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	if len(zoneImport.Candidates) == 0 {
+		fmt.Println("No A/AAAA records found in zone.")
+		return
+	}
+
+	for i, candidate := range zoneImport.Candidates {
+		record := candidate.Record
+		managed := ""
+		if candidate.Managed {
+			managed = " (already managed)"
+		}
+		fmt.Printf("%3d. %-5s %s → %s%s\n", i+1, record.Type, record.Name, record.Content, managed)
+	}
+
+	var selection string
+	fmt.Print("Records to import (e.g. 1,3-5 or all): ")
+	fmt.Scanln(&selection)
+
+	indexes, err := parseSelection(selection, len(zoneImport.Candidates))
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	var recordIDs []string
+	for _, i := range indexes {
+		recordIDs = append(recordIDs, zoneImport.Candidates[i].Record.ID)
+	}
+
+	before := p.snapshotConfig()
+	summary := p.config.ImportRecords(zoneImport, recordIDs)
+	for _, name := range summary.Skipped {
+		fmt.Printf("⚠️ Skipped %s - a record with this name and type is already managed\n", name)
+	}
+
+	if len(summary.Imported) == 0 {
+		fmt.Println("No records imported.")
+		return
+	}
+
+	if err := p.config.save(); err != nil {
+		fmt.Printf("❌ Failed to save config: %v\n", err)
+		return
+	}
+	p.auditCLI(auditRecordsImport, zoneImport.ZoneName, fmt.Sprintf("imported %d, skipped %d", len(summary.Imported), len(summary.Skipped)), before)
+
+	fmt.Printf("✅ Imported %d record(s)\n", len(summary.Imported))
+}
+
+// bundlePassphrase reads the bundle passphrase from --passphrase-file or
+// DDNS_PILOT_BUNDLE_PASSPHRASE, so it never has to appear on the command line
+func bundlePassphrase(passphraseFile string) (string, error) {
+	if passphraseFile != "" {
+		data, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase file: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return os.Getenv("DDNS_PILOT_BUNDLE_PASSPHRASE"), nil
+}
+
+func (p *DDNSPilot) cliExportConfig(opts cliOptions) {
+	passphrase, err := bundlePassphrase(opts.passphraseFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	format := opts.format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(opts.exportFile)), ".")
+		if format != "yaml" && format != "yml" {
+			format = "json"
+		}
+	}
+
+	data, err := exportConfig(p.config, ExportOptions{
+		Format:     format,
+		Redact:     opts.redact,
+		Passphrase: passphrase,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to export config: %v\n", err)
+		os.Exit(1)
+	}
+	p.auditCLI(auditConfigExport, opts.exportFile, fmt.Sprintf("format=%s redacted=%t encrypted=%t", format, opts.redact, passphrase != ""), nil)
+
+	if opts.exportFile == "-" {
+		os.Stdout.Write(data)
+		return
+	}
+
+	if err := os.WriteFile(opts.exportFile, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write export: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Config exported to %s\n", opts.exportFile)
+}
+
+func (p *DDNSPilot) cliImportConfig(opts cliOptions) {
+	passphrase, err := bundlePassphrase(opts.passphraseFile)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	var data []byte
+	if opts.importFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(opts.importFile)
+	}
+	if err != nil {
+		fmt.Printf("❌ Failed to read bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	imported, _, err := parseConfigBundle(data, passphrase)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	plan, err := planConfigImport(p.config, imported, opts.strategy)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("📥 Import (%s strategy): %d change(s)\n", plan.Strategy, len(plan.Changes))
+	for _, change := range plan.Changes {
+		fmt.Printf("   %s\n", change)
+	}
+	for _, warning := range plan.Warnings {
+		fmt.Printf("⚠️ %s\n", warning)
+	}
+
+	if opts.dryRun {
+		fmt.Println("Dry run - no changes written.")
+		return
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Println("Nothing to import.")
+		return
+	}
+
+	before := p.snapshotConfig()
+	if err := p.config.replaceWith(plan.Result); err != nil {
+		fmt.Printf("❌ Failed to apply environment overrides: %v\n", err)
+		os.Exit(1)
+	}
+	if err := p.config.save(); err != nil {
+		fmt.Printf("❌ Failed to save config: %v\n", err)
+		os.Exit(1)
+	}
+	p.auditCLI(auditConfigImport, opts.importFile, plan.Strategy+" strategy", before)
+
+	fmt.Println("✅ Config imported successfully!")
+}
+
+func (p *DDNSPilot) cliCheckDrift(fix bool) {
+	fmt.Println("🔍 Checking DNS records for drift...")
+
+	if len(p.config.Records) == 0 {
+		fmt.Println("❌ No DNS records configured.")
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(p.ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reports := p.ddns.CheckDrift(ctx)
+	drifted := 0
+
+	fmt.Printf("\n📊 Drift Report:\n")
+	for _, report := range reports {
+		switch {
+		case report.Error != "":
+			drifted++
+			fmt.Printf("❌ %s: %s\n", report.RecordName, report.Error)
+		case report.InSync:
+			fmt.Printf("✅ %s: in sync\n", report.RecordName)
+		default:
+			drifted++
+			fmt.Printf("⚠️ %s: drift detected\n", report.RecordName)
+			for _, field := range report.Drift {
+				fmt.Printf("   %s: expected %s, found %s\n", field.Field, field.Expected, field.Actual)
+			}
+		}
+	}
+
+	if fix {
+		for _, report := range reports {
+			if !report.Fixable() || ctx.Err() != nil {
+				continue
+			}
+			before := p.snapshotConfig()
+			result := p.ddns.FixDrift(ctx, report.RecordName, report.RecordType)
+			if result.Success {
+				p.auditCLI(auditDriftFix, result.RecordName, result.Message, before)
+				fmt.Printf("🔧 %s: %s\n", result.RecordName, result.Message)
+				drifted--
+			} else {
+				fmt.Printf("❌ %s: %s\n", result.RecordName, result.Message)
+			}
+		}
+	}
+
+	// Non-zero exit lets cron and monitoring pick up drift
+	if drifted > 0 {
+		os.Exit(1)
+	}
+}
+
+func (p *DDNSPilot) cliSendDigests() {
+	if p.ddns.notifications.SendDigests() > 0 {
+		os.Exit(1)
+	}
+}
+
 func (p *DDNSPilot) startAutoUpdateRoutine() {
 	ticker := time.NewTicker(time.Duration(p.config.UpdateInterval) * time.Minute)
 	defer ticker.Stop()
 
-	log.Printf("Auto-update routine started (interval: %d minutes)", p.config.UpdateInterval)
+	slog.Info("Auto-update routine started", "interval_minutes", p.config.UpdateInterval)
+	p.health.SchedulerStarted()
 
 	for {
 		select {
+		case <-p.stopping.Done():
+			slog.Info("Auto-update routine stopped")
+			return
 		case <-ticker.C:
-			log.Println("Running auto-update...")
-			results := p.ddns.UpdateAllRecords()
+			slog.Debug("Running auto-update")
+			results, ran := p.ddns.UpdateAllRecords(p.ctx)
+			if !ran {
+				slog.Info("Skipping scheduled update, another update is still running")
+				continue
+			}
 
 			// Log results
 			for _, result := range results {
 				if result.Success {
-					if result.OldIP != result.NewIP && result.NewIP != "" {
-						log.Printf("Auto-update: %s updated %s → %s", result.RecordName, result.OldIP, result.NewIP)
+					if result.Changed {
+						slog.Info("Auto-update changed record", "record", result.RecordName, "old_ip", result.OldIP, "new_ip", result.NewIP)
 					}
 				} else {
-					log.Printf("Auto-update error: %s - %s", result.RecordName, result.Message)
+					slog.Error("Auto-update failed", "record", result.RecordName, "error", result.Message)
 				}
 			}
+			p.health.CycleCompleted()
 		}
 	}
 }
@@ -311,6 +805,21 @@ func showUsage() {
 	fmt.Println("  --update      Update all enabled DNS records")
 	fmt.Println("  --add         Add a new DNS record interactively")
 	fmt.Println("  --list        List all configured DNS records")
+	fmt.Println("  --check       Compare records at CloudFlare against the config")
+	fmt.Println("  --fix         With --check, fix drifted records")
+	fmt.Println("  --digest      Send the configured email digests now")
+	fmt.Println("  --import-zone ZONE  Import existing A/AAAA records from a zone")
+	fmt.Println("  --export FILE       Export the config bundle (- for stdout)")
+	fmt.Println("    --format json|yaml  Bundle format (default: from file extension)")
+	fmt.Println("    --redact            Replace API tokens and passwords with REDACTED")
+	fmt.Println("  --import FILE       Import a config bundle (- for stdin)")
+	fmt.Println("    --strategy merge|replace  Merge records by name (default) or replace everything")
+	fmt.Println("    --dry-run           Show the changes without writing them")
+	fmt.Println("  --passphrase-file FILE  Encrypt/decrypt bundles with the passphrase in FILE")
+	fmt.Println("  --config FILE Config file: .json, .yaml/.yml or .toml (default: ddns-pilot.*)")
+	fmt.Println("  --validate    Check the config file and report every problem")
+	fmt.Println("  --log-level LEVEL   debug, info (default), warn or error")
+	fmt.Println("  --log-format FORMAT text (default) or json")
 	fmt.Println("  --help        Show this help message")
 	fmt.Println()
 	fmt.Println("Examples:")
@@ -318,8 +827,18 @@ func showUsage() {
 	fmt.Println("  ddns-pilot --update              # Update all records")
 	fmt.Println("  ddns-pilot --add                 # Add new record")
 	fmt.Println("  ddns-pilot --list                # List records")
+	fmt.Println("  ddns-pilot --check --fix         # Report and fix drifted records")
+	fmt.Println("  ddns-pilot --import-zone example.com  # Pick records to manage from a zone")
+	fmt.Println("  ddns-pilot --export backup.yaml --redact  # Export without secrets")
+	fmt.Println("  ddns-pilot --import backup.json --dry-run # Preview an import")
 	fmt.Println()
 	fmt.Println("Environment Variables:")
-	fmt.Println("  PORT                             # Override web interface port")
+	fmt.Println("  DDNS_PILOT_<SETTING>             # Override any config setting, e.g.")
+	fmt.Println("    DDNS_PILOT_UPDATE_INTERVAL=10    DDNS_PILOT_AUTO_UPDATE=true")
+	fmt.Println("    DDNS_PILOT_WEB_PORT=8080         DDNS_PILOT_RECORDS='[{...}]'")
+	fmt.Println("  PORT                             # Same as DDNS_PILOT_WEB_PORT")
+	fmt.Println("  DDNS_PILOT_BUNDLE_PASSPHRASE     # Bundle passphrase (instead of --passphrase-file)")
+	fmt.Println("  DDNS_PILOT_LOG_LEVEL             # Default for --log-level")
+	fmt.Println("  DDNS_PILOT_LOG_FORMAT            # Default for --log-format")
 	fmt.Println()
 }
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsConfig controls the Prometheus /metrics endpoint
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"` // Separate address such as :9102; empty serves on the web port
+	Token   string `json:"token,omitempty"`  // Bearer token scrapers must send
+}
+
+// latencyBuckets are the histogram bounds for outgoing requests, in seconds
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metricVec is one metric family: a counter, gauge or histogram with a
+// fixed set of label names. It implements the little of the Prometheus
+// client library that DDNS Pilot needs.
+type metricVec struct {
+	name    string
+	help    string
+	kind    string // counter, gauge or histogram
+	labels  []string
+	buckets []float64
+
+	mutex  sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // Counter or gauge value, histogram sum
+	counts      []uint64 // Histogram bucket counts, not cumulative
+	count       uint64
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	vec := newMetricVec("histogram", name, help, labels...)
+	vec.buckets = buckets
+	return vec
+}
+
+func (v *metricVec) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s := v.series[key]
+	if s == nil {
+		s = &metricSeries{labelValues: labelValues}
+		if v.kind == "histogram" {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// Add increases a counter or gauge
+func (v *metricVec) Add(delta float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.get(labelValues).value += delta
+}
+
+// Inc adds one to a counter
+func (v *metricVec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Set sets a gauge
+func (v *metricVec) Set(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.get(labelValues).value = value
+}
+
+// Observe records a histogram sample
+func (v *metricVec) Observe(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	s := v.get(labelValues)
+	s.value += value
+	s.count++
+	for i, bound := range v.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// writeTo renders the family in the Prometheus text format
+func (v *metricVec) writeTo(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		if v.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range v.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "le", formatMetricValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Metrics holds every metric DDNS Pilot exports
+type Metrics struct {
+	updates         *metricVec
+	ipChanges       *metricVec
+	lastSuccess     *metricVec
+	providerLatency *metricVec
+	providerStatus  *metricVec
+	ipSourceLatency *metricVec
+	ipSourceErrors  *metricVec
+	loginFailures   *metricVec
+	records         *metricVec
+	startTime       *metricVec
+}
+
+var metrics = newMetrics()
+
+func newMetrics() *Metrics {
+	m := &Metrics{
+		updates:         newMetricVec("counter", "ddns_pilot_updates_total", "Record updates attempted, by record, type and result (success or failure).", "record", "type", "result"),
+		ipChanges:       newMetricVec("counter", "ddns_pilot_ip_changes_total", "Times a record was changed to a new IP.", "record", "type"),
+		lastSuccess:     newMetricVec("gauge", "ddns_pilot_last_success_timestamp_seconds", "Unix time of the last successful update of a record.", "record", "type"),
+		providerLatency: newHistogramVec("ddns_pilot_provider_request_duration_seconds", "CloudFlare API request latency.", latencyBuckets, "method"),
+		providerStatus:  newMetricVec("counter", "ddns_pilot_provider_responses_total", "CloudFlare API responses by HTTP status code (error when no response arrived).", "method", "code"),
+		ipSourceLatency: newHistogramVec("ddns_pilot_ip_source_duration_seconds", "Public IP lookup latency.", latencyBuckets, "source"),
+		ipSourceErrors:  newMetricVec("counter", "ddns_pilot_ip_source_failures_total", "Failed public IP lookups.", "source"),
+		loginFailures:   newMetricVec("counter", "ddns_pilot_login_failures_total", "Failed web interface logins."),
+		records:         newMetricVec("gauge", "ddns_pilot_records", "Configured records by state.", "state"),
+		startTime:       newMetricVec("gauge", "ddns_pilot_start_time_seconds", "Unix time the process started."),
+	}
+	m.loginFailures.Add(0)
+	m.startTime.Set(float64(time.Now().Unix()))
+	return m
+}
+
+// RecordUpdate counts the result of an update
+func (m *Metrics) RecordUpdate(result *UpdateResult) {
+	recordType := recordTypeOrDefault(result.RecordType)
+	if !result.Success {
+		m.updates.Inc(result.RecordName, recordType, "failure")
+		return
+	}
+	m.updates.Inc(result.RecordName, recordType, "success")
+	m.lastSuccess.Set(float64(result.UpdatedAt.Unix()), result.RecordName, recordType)
+	if result.Changed {
+		m.ipChanges.Inc(result.RecordName, recordType)
+	}
+}
+
+// ObserveProvider records one CloudFlare API call; code is 0 when the
+// request failed before a response arrived
+func (m *Metrics) ObserveProvider(method string, code int, elapsed time.Duration) {
+	m.providerLatency.Observe(elapsed.Seconds(), method)
+	status := "error"
+	if code > 0 {
+		status = strconv.Itoa(code)
+	}
+	m.providerStatus.Inc(method, status)
+}
+
+// ObserveIPSource records one public IP lookup
+func (m *Metrics) ObserveIPSource(source string, elapsed time.Duration, err error) {
+	m.ipSourceLatency.Observe(elapsed.Seconds(), source)
+	if err != nil {
+		m.ipSourceErrors.Inc(source)
+	} else {
+		m.ipSourceErrors.Add(0, source)
+	}
+}
+
+// WriteTo renders every metric, refreshing the ones derived from the config
+func (m *Metrics) WriteTo(w io.Writer, config *AppConfig) {
+	enabled, disabled := 0, 0
+	for _, record := range config.Records {
+		if record.Enabled {
+			enabled++
+		} else {
+			disabled++
+		}
+	}
+	m.records.Set(float64(enabled), "enabled")
+	m.records.Set(float64(disabled), "disabled")
+
+	for _, vec := range []*metricVec{
+		m.updates, m.ipChanges, m.lastSuccess,
+		m.providerLatency, m.providerStatus,
+		m.ipSourceLatency, m.ipSourceErrors,
+		m.loginFailures, m.records, m.startTime,
+	} {
+		vec.writeTo(w)
+	}
+}
+
+// handleMetrics serves /metrics. With a token configured, scrapers must
+// send it as a bearer token; on the web port a logged-in session works too.
+func (p *DDNSPilot) handleMetrics(sessionAllowed bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.metricsAuthorized(r, sessionAllowed) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WriteTo(w, p.config)
+	}
+}
+
+func (p *DDNSPilot) metricsAuthorized(r *http.Request, sessionAllowed bool) bool {
+	token := p.config.Metrics.Token
+	if token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	if sessionAllowed {
+		if cookie, err := r.Cookie("session_id"); err == nil {
+			if _, valid := sessionManager.GetSession(cookie.Value); valid {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A separate listener is open unless a token is set
+	return token == ""
+}
+
+// startMetricsListener serves /metrics on its own address
+func (p *DDNSPilot) startMetricsListener() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", p.handleMetrics(false))
+
+	server := &http.Server{Addr: p.config.Metrics.Listen, Handler: mux}
+	go func() {
+		<-p.stopping.Done()
+		server.Close()
+	}()
+
+	slog.Info("Serving metrics", "listen", p.config.Metrics.Listen, "path", "/metrics")
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		slog.Error("Metrics listener failed", "error", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// currentSchemaVersion is the config schema this build reads and writes.
+// Bump it together with a new entry in configMigrations.
+const currentSchemaVersion = 1
+
+// configMigration upgrades a raw config document by exactly one version
+type configMigration struct {
+	From        int
+	Description string
+	Apply       func(raw map[string]interface{}) error
+}
+
+// configMigrations must stay ordered: entry i upgrades version i to i+1
+var configMigrations = []configMigration{
+	{
+		From:        0,
+		Description: "add schema_version and fill in defaults for unset port, session timeout and update interval",
+		Apply:       migrateV0ToV1,
+	},
+}
+
+// Version 0 configs predate schema_version and relied on zero values being
+// patched at every load
+func migrateV0ToV1(raw map[string]interface{}) error {
+	web, _ := raw["web"].(map[string]interface{})
+	if web == nil {
+		web = make(map[string]interface{})
+		raw["web"] = web
+	}
+	setIfZero(web, "port", 8082)
+	setIfZero(web, "session_timeout", 60)
+	setIfZero(raw, "update_interval", 5)
+	return nil
+}
+
+// setIfZero sets a numeric field that is missing or zero
+func setIfZero(m map[string]interface{}, key string, value float64) {
+	if current, ok := m[key].(float64); !ok || current == 0 {
+		m[key] = value
+	}
+}
+
+// schemaVersionOf reads schema_version from a raw document (0 if absent)
+func schemaVersionOf(raw map[string]interface{}) (int, error) {
+	value, exists := raw["schema_version"]
+	if !exists {
+		return 0, nil
+	}
+	version, ok := value.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", value)
+	}
+	return int(version), nil
+}
+
+// migrateConfigTree upgrades a raw config document to currentSchemaVersion.
+// It returns the version the document started at and refuses documents
+// written by a newer build, whose fields this build would silently drop.
+func migrateConfigTree(raw map[string]interface{}) (int, error) {
+	from, err := schemaVersionOf(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	if from > currentSchemaVersion {
+		return from, fmt.Errorf("config schema version %d is newer than this build supports (%d) - upgrade DDNS Pilot", from, currentSchemaVersion)
+	}
+
+	for version := from; version < currentSchemaVersion; version++ {
+		migration := configMigrations[version]
+		if migration.From != version {
+			return from, fmt.Errorf("missing config migration from version %d", version)
+		}
+		if err := migration.Apply(raw); err != nil {
+			return from, fmt.Errorf("config migration %d → %d failed: %v", version, version+1, err)
+		}
+		raw["schema_version"] = float64(version + 1)
+		slog.Info("Applying config migration", "from_schema", version, "to_schema", version+1, "description", migration.Description)
+	}
+
+	return from, nil
+}
+
+// backupConfigFile copies the pre-migration config next to the original
+func backupConfigFile(path string, data []byte, version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102T150405"))
+	if err := os.WriteFile(backupPath, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write config backup: %v", err)
+	}
+	return backupPath, nil
+}
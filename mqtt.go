@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mqttUpdateCommand is the payload on the command topic that runs an update
+const mqttUpdateCommand = "update"
+
+const (
+	mqttRetryMin = 5 * time.Second
+	mqttRetryMax = 5 * time.Minute
+)
+
+// MQTTConfig configures publishing state to an MQTT broker
+type MQTTConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Broker          string `json:"broker,omitempty"` // e.g. tcp://localhost:1883 or mqtts://broker:8883
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	ClientID        string `json:"client_id"`
+	TopicPrefix     string `json:"topic_prefix"`
+	Discovery       bool   `json:"discovery"` // Publish Home Assistant discovery payloads
+	DiscoveryPrefix string `json:"discovery_prefix"`
+	Commands        bool   `json:"commands"`   // Accept "update" on <prefix>/command
+	KeepAlive       int    `json:"keep_alive"` // Seconds
+}
+
+// mqttRecordState is the retained payload of a record's state topic
+type mqttRecordState struct {
+	Record     string `json:"record"`
+	Type       string `json:"type"`
+	Enabled    bool   `json:"enabled"`
+	Status     string `json:"status"` // changed, unchanged, failed or unknown
+	IP         string `json:"ip,omitempty"`
+	OldIP      string `json:"old_ip,omitempty"`
+	Message    string `json:"message,omitempty"`
+	LastUpdate string `json:"last_update,omitempty"`
+}
+
+// MQTTPublisher keeps the broker's retained topics in step with the records
+// and reconnects on its own. Everything published is remembered so it can
+// be replayed after a reconnect.
+type MQTTPublisher struct {
+	config  *AppConfig
+	history *HistoryStore
+
+	mutex     sync.Mutex
+	client    *mqttClient
+	retained  map[string][]byte
+	order     []string        // Topics in first-published order, for replay
+	announced map[string]bool // Records with discovery payloads
+}
+
+func NewMQTTPublisher(config *AppConfig, history *HistoryStore) *MQTTPublisher {
+	return &MQTTPublisher{
+		config:    config,
+		history:   history,
+		retained:  make(map[string][]byte),
+		announced: make(map[string]bool),
+	}
+}
+
+func (m *MQTTPublisher) topic(parts ...string) string {
+	return strings.Join(append([]string{m.config.MQTT.TopicPrefix}, parts...), "/")
+}
+
+// Run connects and stays connected until ctx is cancelled. update is
+// started in the background for every "update" command when commands are
+// enabled; Run waits for any still running before it returns.
+func (m *MQTTPublisher) Run(ctx context.Context, update func()) {
+	cfg := m.config.MQTT
+	if !cfg.Enabled {
+		return
+	}
+
+	var updates sync.WaitGroup
+	defer updates.Wait()
+	startUpdate := func() {
+		updates.Add(1)
+		go func() {
+			defer updates.Done()
+			update()
+		}()
+	}
+
+	m.publishInitialState()
+
+	backoff := mqttRetryMin
+	for {
+		started := time.Now()
+		err := m.session(ctx, cfg, startUpdate)
+		if ctx.Err() != nil {
+			slog.Info("Disconnected from MQTT broker", "broker", cfg.Broker)
+			return
+		}
+		slog.Warn("MQTT connection lost", "broker", cfg.Broker, "error", err)
+
+		// A session that lasted a while resets the backoff
+		if time.Since(started) > mqttRetryMax {
+			backoff = mqttRetryMin
+		}
+		slog.Info("Reconnecting to MQTT broker", "broker", cfg.Broker, "in", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > mqttRetryMax {
+			backoff = mqttRetryMax
+		}
+	}
+}
+
+// session runs one connection from connect to failure. startUpdate must
+// not block: the connection isn't read while it runs.
+func (m *MQTTPublisher) session(ctx context.Context, cfg MQTTConfig, startUpdate func()) error {
+	availability := m.topic("status")
+	client, err := dialMQTT(cfg, &mqttMessage{Topic: availability, Payload: []byte("offline"), Retain: true})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	slog.Info("Connected to MQTT broker", "broker", cfg.Broker)
+
+	if err := client.Publish(mqttMessage{Topic: availability, Payload: []byte("online"), Retain: true}); err != nil {
+		return err
+	}
+	if cfg.Commands {
+		if err := client.Subscribe(m.topic("command")); err != nil {
+			return err
+		}
+	}
+
+	// Replay discovery and state, then let publish() write straight through
+	m.mutex.Lock()
+	for _, topic := range m.order {
+		if err := client.Publish(mqttMessage{Topic: topic, Payload: m.retained[topic], Retain: true}); err != nil {
+			m.mutex.Unlock()
+			return err
+		}
+	}
+	m.client = client
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		m.client = nil
+		m.mutex.Unlock()
+	}()
+
+	// On shutdown, mark the bridge offline ourselves: a clean disconnect
+	// means the broker drops the last will
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.mutex.Lock()
+			client.Publish(mqttMessage{Topic: availability, Payload: []byte("offline"), Retain: true})
+			client.Disconnect()
+			m.mutex.Unlock()
+		case <-finished:
+		}
+	}()
+
+	keepAlive := time.Duration(cfg.KeepAlive) * time.Second
+	if keepAlive > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(keepAlive / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := client.Ping(); err != nil {
+						client.Close()
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		// Pings are answered well within the keepalive; silence beyond it
+		// means the connection is dead
+		msg, err := client.ReadMessage(keepAlive * 3 / 2)
+		if err != nil {
+			return err
+		}
+		if msg.Topic != m.topic("command") {
+			continue
+		}
+
+		command := strings.ToLower(strings.TrimSpace(string(msg.Payload)))
+		if command != mqttUpdateCommand {
+			slog.Warn("Ignoring unknown MQTT command", "command", command)
+			continue
+		}
+		slog.Info("Update requested over MQTT")
+		startUpdate()
+	}
+}
+
+// publish sends a retained message now if connected, and again after every
+// reconnect
+func (m *MQTTPublisher) publish(topic string, payload []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, known := m.retained[topic]; !known {
+		m.order = append(m.order, topic)
+	}
+	m.retained[topic] = payload
+
+	if m.client != nil {
+		if err := m.client.Publish(mqttMessage{Topic: topic, Payload: payload, Retain: true}); err != nil {
+			slog.Warn("Failed to publish to MQTT", "topic", topic, "error", err)
+			// The read loop notices the broken connection and reconnects
+			m.client.Close()
+		}
+	}
+}
+
+func (m *MQTTPublisher) publishJSON(topic string, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		slog.Warn("Failed to encode MQTT payload", "topic", topic, "error", err)
+		return
+	}
+	m.publish(topic, payload)
+}
+
+// publishInitialState seeds every record's topic from the config and the
+// most recent history entry
+func (m *MQTTPublisher) publishInitialState() {
+	var latest map[string][]HistoryEntry
+	if m.history != nil {
+		var err error
+		if latest, err = m.history.Timelines(1); err != nil {
+			slog.Warn("Failed to read history for MQTT", "error", err)
+		}
+	}
+
+	m.announceDevice()
+	for i := range m.config.Records {
+		record := &m.config.Records[i]
+		m.announceRecord(record)
+
+		state := mqttRecordState{
+			Record:     record.RecordName,
+			Type:       record.Type(),
+			Enabled:    record.Enabled,
+			Status:     "unknown",
+			IP:         record.LastIP,
+			LastUpdate: record.LastUpdated,
+		}
+		if entries := latest[record.Key()]; len(entries) > 0 {
+			entry := entries[0]
+			state.Status = entry.Status()
+			state.OldIP = entry.OldIP
+			state.Message = entry.Message
+			state.LastUpdate = entry.Time.Format(time.RFC3339)
+			if entry.NewIP != "" {
+				state.IP = entry.NewIP
+			}
+		}
+		m.publishJSON(m.topic("records", record.Key(), "state"), state)
+		if state.IP != "" && state.Status != "failed" {
+			m.publishPublicIP(record.Type(), state.IP)
+		}
+	}
+}
+
+// PublishResult publishes the outcome of an update or drift fix
+func (m *MQTTPublisher) PublishResult(record *DDNSRecord, result *UpdateResult) {
+	if !m.config.MQTT.Enabled {
+		return
+	}
+
+	state := mqttRecordState{
+		Record:     result.RecordName,
+		Type:       recordTypeOrDefault(result.RecordType),
+		Status:     "unchanged",
+		IP:         result.NewIP,
+		OldIP:      result.OldIP,
+		Message:    result.Message,
+		LastUpdate: result.UpdatedAt.Format(time.RFC3339),
+	}
+	if record != nil {
+		m.announceRecord(record)
+		state.Type = record.Type()
+		state.Enabled = record.Enabled
+		if state.IP == "" {
+			state.IP = record.LastIP
+		}
+	}
+	switch {
+	case !result.Success:
+		state.Status = "failed"
+	case result.Changed:
+		state.Status = "changed"
+	}
+
+	m.publishJSON(m.topic("records", recordKey(result.RecordName, state.Type), "state"), state)
+	m.publishJSON(m.topic("last_result"), state)
+	if result.NewIP != "" {
+		m.publishPublicIP(state.Type, result.NewIP)
+	}
+}
+
+func (m *MQTTPublisher) publishPublicIP(recordType, ip string) {
+	if net.ParseIP(ip) == nil {
+		return
+	}
+	if recordType == "AAAA" {
+		m.publish(m.topic("public_ipv6"), []byte(ip))
+	} else {
+		m.publish(m.topic("public_ip"), []byte(ip))
+	}
+}
+
+var mqttObjectIDPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// mqttObjectID turns a record name into a Home Assistant object ID
+func mqttObjectID(name string) string {
+	return strings.Trim(mqttObjectIDPattern.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// discoveryTopic returns where Home Assistant looks for an entity config
+func (m *MQTTPublisher) discoveryTopic(component, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", m.config.MQTT.DiscoveryPrefix, component, mqttObjectID(m.config.MQTT.ClientID), objectID)
+}
+
+// announce publishes a Home Assistant discovery config for one entity
+func (m *MQTTPublisher) announce(component, objectID string, payload map[string]interface{}) {
+	if !m.config.MQTT.Discovery {
+		return
+	}
+	node := mqttObjectID(m.config.MQTT.ClientID)
+	payload["unique_id"] = node + "_" + objectID
+	payload["object_id"] = node + "_" + objectID
+	payload["availability_topic"] = m.topic("status")
+	payload["device"] = map[string]interface{}{
+		"identifiers":  []string{node},
+		"name":         "DDNS Pilot",
+		"manufacturer": "DDNS Pilot",
+		"model":        "CloudFlare Dynamic DNS",
+	}
+	m.publishJSON(m.discoveryTopic(component, objectID), payload)
+}
+
+// announceDevice publishes the public IP sensors and, with commands
+// enabled, the update button
+func (m *MQTTPublisher) announceDevice() {
+	m.announce("sensor", "public_ip", map[string]interface{}{
+		"name":        "Public IP",
+		"state_topic": m.topic("public_ip"),
+		"icon":        "mdi:ip-network",
+	})
+	m.announce("sensor", "public_ipv6", map[string]interface{}{
+		"name":        "Public IPv6",
+		"state_topic": m.topic("public_ipv6"),
+		"icon":        "mdi:ip-network",
+	})
+	if m.config.MQTT.Commands {
+		m.announce("button", "update", map[string]interface{}{
+			"name":          "Update now",
+			"command_topic": m.topic("command"),
+			"payload_press": mqttUpdateCommand,
+			"icon":          "mdi:refresh",
+		})
+	}
+}
+
+// announceRecord publishes an IP sensor and a problem sensor for a record,
+// once; records added later are announced with their first result
+func (m *MQTTPublisher) announceRecord(record *DDNSRecord) {
+	m.mutex.Lock()
+	known := m.announced[strings.ToLower(record.Key())]
+	m.announced[strings.ToLower(record.Key())] = true
+	m.mutex.Unlock()
+	if known {
+		return
+	}
+
+	id := mqttObjectID(record.Key())
+	stateTopic := m.topic("records", record.Key(), "state")
+	name := record.RecordName
+	if record.Type() != defaultRecordType {
+		name += " " + record.Type()
+	}
+	m.announce("sensor", id+"_ip", map[string]interface{}{
+		"name":                  name,
+		"state_topic":           stateTopic,
+		"value_template":        "{{ value_json.ip }}",
+		"json_attributes_topic": stateTopic,
+		"icon":                  "mdi:dns",
+	})
+	m.announce("binary_sensor", id+"_problem", map[string]interface{}{
+		"name":           name + " problem",
+		"state_topic":    stateTopic,
+		"value_template": "{{ 'ON' if value_json.status == 'failed' else 'OFF' }}",
+		"device_class":   "problem",
+	})
+}
+
+// validateMQTT checks the MQTT settings when publishing is enabled
+func validateMQTT(cfg MQTTConfig, errs *ValidationErrors) {
+	if !cfg.Enabled {
+		return
+	}
+
+	broker, err := url.Parse(cfg.Broker)
+	switch {
+	case cfg.Broker == "":
+		errs.add("$.mqtt.broker", "required when MQTT is enabled")
+	case err != nil || broker.Hostname() == "":
+		errs.add("$.mqtt.broker", "must be a URL like tcp://localhost:1883, got %q", cfg.Broker)
+	default:
+		switch broker.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts":
+		default:
+			errs.add("$.mqtt.broker", "unknown scheme %q (use tcp, mqtt, ssl, tls or mqtts)", broker.Scheme)
+		}
+	}
+
+	if cfg.ClientID == "" {
+		errs.add("$.mqtt.client_id", "must not be empty")
+	}
+	for field, topic := range map[string]string{"topic_prefix": cfg.TopicPrefix, "discovery_prefix": cfg.DiscoveryPrefix} {
+		if topic == "" {
+			errs.add("$.mqtt."+field, "must not be empty")
+		} else if strings.ContainsAny(topic, "+#") {
+			errs.add("$.mqtt."+field, "must not contain the wildcards + or #, got %q", topic)
+		}
+	}
+	if cfg.KeepAlive < 0 || cfg.KeepAlive > 65535 {
+		errs.add("$.mqtt.keep_alive", "must be between 0 and 65535 seconds, got %d", cfg.KeepAlive)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, as the high nibble of the first byte
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+const (
+	mqttMaxPacket   = 1 << 20
+	mqttDialTimeout = 10 * time.Second
+)
+
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client ID rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+// mqttMessage is an application message sent or received
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client: QoS 0 publish and subscribe,
+// keepalive pings and a last will. That is all DDNS Pilot needs.
+type mqttClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMutex sync.Mutex
+	packetID   uint16
+}
+
+// dialMQTT connects and logs in to a broker given as tcp://, mqtt://,
+// ssl://, tls:// or mqtts:// URL
+func dialMQTT(cfg MQTTConfig, will *mqttMessage) (*mqttClient, error) {
+	broker, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %v", err)
+	}
+
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	var conn net.Conn
+	switch broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostWithPort(broker, "8883"), &tls.Config{ServerName: broker.Hostname()})
+	default:
+		conn, err = dialer.Dial("tcp", hostWithPort(broker, "1883"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", broker.Host, err)
+	}
+
+	c := &mqttClient{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.handshake(cfg, will); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func hostWithPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func (c *mqttClient) handshake(cfg MQTTConfig, will *mqttMessage) error {
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4) // Protocol level 3.1.1
+
+	flags := byte(0x02) // Clean session
+	if will != nil {
+		flags |= 0x04
+		if will.Retain {
+			flags |= 0x20
+		}
+	}
+	if cfg.Username != "" {
+		flags |= 0x80
+		if cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(cfg.KeepAlive))
+
+	body = appendMQTTString(body, cfg.ClientID)
+	if will != nil {
+		body = appendMQTTString(body, will.Topic)
+		body = appendMQTTBytes(body, will.Payload)
+	}
+	if cfg.Username != "" {
+		body = appendMQTTString(body, cfg.Username)
+		if cfg.Password != "" {
+			body = appendMQTTString(body, cfg.Password)
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.writePacket(mqttConnect<<4, body); err != nil {
+		return err
+	}
+
+	header, ack, err := c.readPacket()
+	if err != nil {
+		return fmt.Errorf("no CONNACK from broker: %v", err)
+	}
+	if header>>4 != mqttConnack || len(ack) != 2 {
+		return fmt.Errorf("unexpected reply to CONNECT (packet type %d)", header>>4)
+	}
+	if ack[1] != 0 {
+		reason := mqttConnackErrors[ack[1]]
+		if reason == "" {
+			reason = fmt.Sprintf("code %d", ack[1])
+		}
+		return fmt.Errorf("broker refused connection: %s", reason)
+	}
+	return nil
+}
+
+// Publish sends a QoS 0 message
+func (c *mqttClient) Publish(msg mqttMessage) error {
+	header := byte(mqttPublish << 4)
+	if msg.Retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, msg.Topic)
+	body = append(body, msg.Payload...)
+	return c.writePacket(header, body)
+}
+
+// Subscribe asks for QoS 0 delivery of a topic filter. The SUBACK arrives
+// through ReadMessage like any other packet.
+func (c *mqttClient) Subscribe(topic string) error {
+	c.writeMutex.Lock()
+	c.packetID++
+	id := c.packetID
+	c.writeMutex.Unlock()
+
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, topic)
+	body = append(body, 0) // Requested QoS
+	return c.writePacket(mqttSubscribe<<4|0x02, body)
+}
+
+// Ping sends a keepalive
+func (c *mqttClient) Ping() error {
+	return c.writePacket(mqttPingreq<<4, nil)
+}
+
+// Disconnect closes the session cleanly, so the broker drops the last will
+func (c *mqttClient) Disconnect() {
+	c.writePacket(mqttDisconnect<<4, nil)
+	c.conn.Close()
+}
+
+// Close drops the connection; the broker publishes the last will
+func (c *mqttClient) Close() {
+	c.conn.Close()
+}
+
+// ReadMessage blocks until the next application message, handling
+// acknowledgements and ping responses on the way. timeout bounds the wait
+// for any packet, which catches dead connections.
+func (c *mqttClient) ReadMessage(timeout time.Duration) (*mqttMessage, error) {
+	for {
+		if timeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		header, body, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		switch header >> 4 {
+		case mqttPingresp:
+			continue
+		case mqttSuback:
+			if len(body) == 3 && body[2] == 0x80 {
+				return nil, errors.New("broker rejected the subscription")
+			}
+			continue
+		case mqttPublish:
+			msg, id, err := parseMQTTPublish(header, body)
+			if err != nil {
+				return nil, err
+			}
+			if id != 0 {
+				// QoS 1 delivery despite asking for QoS 0; acknowledge it
+				if err := c.writePacket(mqttPuback<<4, binary.BigEndian.AppendUint16(nil, id)); err != nil {
+					return nil, err
+				}
+			}
+			return msg, nil
+		default:
+			continue
+		}
+	}
+}
+
+func parseMQTTPublish(header byte, body []byte) (*mqttMessage, uint16, error) {
+	if len(body) < 2 {
+		return nil, 0, errors.New("short PUBLISH packet")
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+topicLen {
+		return nil, 0, errors.New("short PUBLISH topic")
+	}
+	msg := &mqttMessage{Topic: string(body[2 : 2+topicLen]), Retain: header&0x01 != 0}
+	rest := body[2+topicLen:]
+
+	var id uint16
+	if (header>>1)&0x03 > 0 {
+		if len(rest) < 2 {
+			return nil, 0, errors.New("short PUBLISH packet ID")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	msg.Payload = rest
+	return msg, id, nil
+}
+
+func (c *mqttClient) writePacket(header byte, body []byte) error {
+	packet := []byte{header}
+	packet = appendMQTTLength(packet, len(body))
+	packet = append(packet, body...)
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *mqttClient) readPacket() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+		multiplier *= 128
+	}
+	if length > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendMQTTLength(b []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			return b
+		}
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	return appendMQTTBytes(b, []byte(s))
+}
+
+func appendMQTTBytes(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
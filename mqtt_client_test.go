@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeMQTTClients returns a client and the broker end of an in-memory
+// connection. Both ends use mqttClient for packet framing.
+func pipeMQTTClients(t *testing.T) (client, broker *mqttClient) {
+	t.Helper()
+	clientConn, brokerConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		brokerConn.Close()
+	})
+	return &mqttClient{conn: clientConn, reader: bufio.NewReader(clientConn)},
+		&mqttClient{conn: brokerConn, reader: bufio.NewReader(brokerConn)}
+}
+
+// readerMQTTClient reads packets from fixed bytes
+func readerMQTTClient(data []byte) *mqttClient {
+	return &mqttClient{reader: bufio.NewReader(bytes.NewReader(data))}
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	tests := []struct {
+		length  int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{321, []byte{0xc1, 0x02}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+		{268435455, []byte{0xff, 0xff, 0xff, 0x7f}},
+	}
+
+	for _, tt := range tests {
+		if got := appendMQTTLength(nil, tt.length); !bytes.Equal(got, tt.encoded) {
+			t.Errorf("appendMQTTLength(%d) = % x, want % x", tt.length, got, tt.encoded)
+		}
+
+		packet := append([]byte{mqttPublish << 4}, tt.encoded...)
+		if tt.length > mqttMaxPacket {
+			_, _, err := readerMQTTClient(packet).readPacket()
+			if err == nil || !strings.Contains(err.Error(), "too large") {
+				t.Errorf("readPacket with length %d: error = %v, want too large", tt.length, err)
+			}
+			continue
+		}
+
+		body := bytes.Repeat([]byte{'x'}, tt.length)
+		header, got, err := readerMQTTClient(append(packet, body...)).readPacket()
+		if err != nil {
+			t.Errorf("readPacket with length %d: %v", tt.length, err)
+			continue
+		}
+		if header != mqttPublish<<4 || len(got) != tt.length {
+			t.Errorf("readPacket with length %d = header %#x, %d bytes", tt.length, header, len(got))
+		}
+	}
+}
+
+func TestMQTTReadPacketErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   string
+	}{
+		{"empty", nil, "EOF"},
+		{"missing length", []byte{0x30}, "EOF"},
+		{"unfinished length", []byte{0x30, 0x80}, "EOF"},
+		{"five length bytes", []byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}, "malformed packet length"},
+		{"short body", []byte{0x30, 0x05, 'a', 'b'}, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readerMQTTClient(tt.packet).readPacket()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readPacket(% x) error = %v, want %q", tt.packet, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTPacketFraming(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(c *mqttClient) error
+		want  []byte
+	}{
+		{
+			name:  "publish",
+			write: func(c *mqttClient) error { return c.Publish(mqttMessage{Topic: "a/b", Payload: []byte("hi")}) },
+			want:  []byte{0x30, 0x07, 0x00, 0x03, 'a', '/', 'b', 'h', 'i'},
+		},
+		{
+			name:  "retained publish",
+			write: func(c *mqttClient) error { return c.Publish(mqttMessage{Topic: "t", Retain: true}) },
+			want:  []byte{0x31, 0x03, 0x00, 0x01, 't'},
+		},
+		{
+			name:  "subscribe",
+			write: func(c *mqttClient) error { return c.Subscribe("x/#") },
+			want:  []byte{0x82, 0x08, 0x00, 0x01, 0x00, 0x03, 'x', '/', '#', 0x00},
+		},
+		{
+			name:  "ping",
+			write: func(c *mqttClient) error { return c.Ping() },
+			want:  []byte{0xc0, 0x00},
+		},
+		{
+			name:  "disconnect",
+			write: func(c *mqttClient) error { c.Disconnect(); return nil },
+			want:  []byte{0xe0, 0x00},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, broker := pipeMQTTClients(t)
+
+			errs := make(chan error, 1)
+			go func() { errs <- tt.write(client) }()
+
+			got := make([]byte, len(tt.want))
+			broker.conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(broker.reader, got); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("packet = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMQTTPublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  byte
+		body    []byte
+		want    mqttMessage
+		id      uint16
+		wantErr string
+	}{
+		{
+			name:   "qos 0",
+			header: 0x30,
+			body:   []byte{0x00, 0x01, 't', 'u', 'p'},
+			want:   mqttMessage{Topic: "t", Payload: []byte("up")},
+		},
+		{
+			name:   "retained",
+			header: 0x31,
+			body:   []byte{0x00, 0x01, 't'},
+			want:   mqttMessage{Topic: "t", Payload: []byte{}, Retain: true},
+		},
+		{
+			name:   "qos 1 carries a packet ID",
+			header: 0x32,
+			body:   []byte{0x00, 0x01, 't', 0x12, 0x34, 'u', 'p'},
+			want:   mqttMessage{Topic: "t", Payload: []byte("up")},
+			id:     0x1234,
+		},
+		{name: "no topic length", header: 0x30, body: []byte{0x00}, wantErr: "short PUBLISH packet"},
+		{name: "topic cut short", header: 0x30, body: []byte{0x00, 0x05, 't'}, wantErr: "short PUBLISH topic"},
+		{name: "qos 1 without ID", header: 0x32, body: []byte{0x00, 0x01, 't', 0x01}, wantErr: "short PUBLISH packet ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, id, err := parseMQTTPublish(tt.header, tt.body)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if msg.Topic != tt.want.Topic || !bytes.Equal(msg.Payload, tt.want.Payload) || msg.Retain != tt.want.Retain || id != tt.id {
+				t.Errorf("got %+v id %d, want %+v id %d", *msg, id, tt.want, tt.id)
+			}
+		})
+	}
+}
+
+// TestMQTTSession runs CONNECT, SUBSCRIBE and an incoming PUBLISH against a
+// fake broker
+func TestMQTTSession(t *testing.T) {
+	client, broker := pipeMQTTClients(t)
+	cfg := MQTTConfig{ClientID: "ddns-test", Username: "user", Password: "secret", KeepAlive: 60}
+	will := &mqttMessage{Topic: "ddns/status", Payload: []byte("offline"), Retain: true}
+
+	brokerDone := make(chan struct{})
+	go func() {
+		defer close(brokerDone)
+
+		header, body, err := broker.readPacket()
+		if err != nil || header != mqttConnect<<4 {
+			t.Errorf("CONNECT: header %#x, error %v", header, err)
+			return
+		}
+		var want []byte
+		want = appendMQTTString(want, "MQTT")
+		want = append(want, 4, 0x02|0x04|0x20|0x80|0x40)
+		want = binary.BigEndian.AppendUint16(want, 60)
+		want = appendMQTTString(want, "ddns-test")
+		want = appendMQTTString(want, "ddns/status")
+		want = appendMQTTString(want, "offline")
+		want = appendMQTTString(want, "user")
+		want = appendMQTTString(want, "secret")
+		if !bytes.Equal(body, want) {
+			t.Errorf("CONNECT body = % x, want % x", body, want)
+		}
+		if err := broker.writePacket(mqttConnack<<4, []byte{0, 0}); err != nil {
+			t.Errorf("CONNACK: %v", err)
+			return
+		}
+
+		header, body, err = broker.readPacket()
+		if err != nil || header != mqttSubscribe<<4|0x02 {
+			t.Errorf("SUBSCRIBE: header %#x, error %v", header, err)
+			return
+		}
+		if err := broker.writePacket(mqttSuback<<4, append(body[:2:2], 0)); err != nil {
+			t.Errorf("SUBACK: %v", err)
+			return
+		}
+
+		// QoS 1 although QoS 0 was asked for: the client has to acknowledge
+		publish := appendMQTTString(nil, "ddns/command")
+		publish = binary.BigEndian.AppendUint16(publish, 7)
+		publish = append(publish, "update"...)
+		if err := broker.writePacket(mqttPublish<<4|0x02, publish); err != nil {
+			t.Errorf("PUBLISH: %v", err)
+			return
+		}
+		header, body, err = broker.readPacket()
+		if err != nil || header != mqttPuback<<4 || !bytes.Equal(body, []byte{0, 7}) {
+			t.Errorf("PUBACK: header %#x, body % x, error %v", header, body, err)
+		}
+	}()
+
+	if err := client.handshake(cfg, will); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if err := client.Subscribe("ddns/command"); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	msg, err := client.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if msg.Topic != "ddns/command" || string(msg.Payload) != "update" {
+		t.Errorf("message = %q %q, want ddns/command update", msg.Topic, msg.Payload)
+	}
+	<-brokerDone
+}
+
+func TestMQTTHandshakeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		want  string
+	}{
+		{"refused", []byte{0x20, 0x02, 0x00, 0x04}, "broker refused connection: bad username or password"},
+		{"unknown code", []byte{0x20, 0x02, 0x00, 0x09}, "broker refused connection: code 9"},
+		{"not a CONNACK", []byte{0xd0, 0x00}, "unexpected reply to CONNECT (packet type 13)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, broker := pipeMQTTClients(t)
+			go func() {
+				if _, _, err := broker.readPacket(); err == nil {
+					broker.conn.Write(tt.reply)
+				}
+			}()
+
+			err := client.handshake(MQTTConfig{ClientID: "ddns-test"}, nil)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("handshake error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTSubscriptionRejected(t *testing.T) {
+	client, broker := pipeMQTTClients(t)
+	go broker.writePacket(mqttSuback<<4, []byte{0x00, 0x01, 0x80})
+
+	if _, err := client.ReadMessage(time.Second); err == nil || !strings.Contains(err.Error(), "rejected the subscription") {
+		t.Errorf("ReadMessage error = %v, want rejected subscription", err)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification events
+const (
+	eventIPChanged     = "ip_changed"
+	eventUpdateFailed  = "update_failed"
+	eventRecovered     = "recovered"
+	eventDriftDetected = "drift_detected"
+	eventTest          = "test"
+)
+
+// notificationEvents lists the events a notifier can subscribe to
+var notificationEvents = []string{eventIPChanged, eventUpdateFailed, eventRecovered, eventDriftDetected}
+
+// Notifier types
+const (
+	notifierWebhook  = "webhook"
+	notifierSlack    = "slack"
+	notifierDiscord  = "discord"
+	notifierTelegram = "telegram"
+	notifierMatrix   = "matrix"
+	notifierNtfy     = "ntfy"
+	notifierGotify   = "gotify"
+	notifierEmail    = "email"
+)
+
+var notifierTypes = []string{notifierWebhook, notifierSlack, notifierDiscord, notifierTelegram, notifierMatrix, notifierNtfy, notifierGotify, notifierEmail}
+
+const (
+	defaultNotifierRetries = 3
+	defaultNotifierTimeout = 10 // seconds
+)
+
+// NotifierConfig configures one notification target
+type NotifierConfig struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Enabled bool     `json:"enabled"`
+	Events  []string `json:"events,omitempty"`  // Empty means every event
+	Records []string `json:"records,omitempty"` // Empty means every record
+
+	// Go template for the message text of chat notifiers
+	MessageTemplate string `json:"message_template,omitempty"`
+
+	// Webhook settings
+	URL          string            `json:"url,omitempty"`
+	Method       string            `json:"method,omitempty"` // Defaults to POST
+	Headers      map[string]string `json:"headers,omitempty"`
+	BodyTemplate string            `json:"body_template,omitempty"` // Go template; defaults to the event as JSON
+
+	// Chat notifier settings; which apply depends on the type
+	WebhookURL string `json:"webhook_url,omitempty"` // Slack, Discord
+	Server     string `json:"server,omitempty"`      // Matrix homeserver, ntfy and Gotify server, Telegram API
+	Token      string `json:"token,omitempty"`       // Telegram bot, Matrix access, ntfy access or Gotify app token
+	ChatID     string `json:"chat_id,omitempty"`     // Telegram
+	RoomID     string `json:"room_id,omitempty"`     // Matrix
+	Topic      string `json:"topic,omitempty"`       // ntfy
+	Username   string `json:"username,omitempty"`    // ntfy basic auth, SMTP auth
+	Password   string `json:"password,omitempty"`    // ntfy basic auth, SMTP auth
+	Priority   int    `json:"priority,omitempty"`    // ntfy (1-5) and Gotify (0-10)
+
+	// Email settings
+	SMTPHost   string   `json:"smtp_host,omitempty"`
+	SMTPPort   int      `json:"smtp_port,omitempty"` // Defaults to 587, 465 or 25 by security
+	Security   string   `json:"security,omitempty"`  // starttls (default), tls or none
+	From       string   `json:"from,omitempty"`
+	To         []string `json:"to,omitempty"`
+	Digest     string   `json:"digest,omitempty"`      // daily or weekly summary on top of the events
+	DigestTime string   `json:"digest_time,omitempty"` // HH:MM local time; defaults to 08:00
+
+	Retries *int `json:"retries,omitempty"` // Extra attempts after a failure; defaults to 3
+	Timeout int  `json:"timeout,omitempty"` // Seconds per attempt
+}
+
+// Subscribed reports whether the notifier wants an event
+func (n NotifierConfig) Subscribed(event string) bool {
+	if event == eventTest || len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether the notifier is interested in a record
+func (n NotifierConfig) Covers(recordName string) bool {
+	if len(n.Records) == 0 || recordName == "" {
+		return true
+	}
+	for _, name := range n.Records {
+		if strings.EqualFold(name, recordName) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationEvent is what notifiers receive and templates render
+type NotificationEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	RecordName string    `json:"record,omitempty"`
+	RecordType string    `json:"record_type,omitempty"`
+	OldIP      string    `json:"old_ip,omitempty"`
+	NewIP      string    `json:"new_ip,omitempty"`
+	Message    string    `json:"message"`
+	Success    bool      `json:"success"`
+}
+
+// notifierSender delivers one event to one target
+type notifierSender interface {
+	Send(event NotificationEvent) error
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// templateFuncs are available in body and message templates
+var templateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, e.g. {"text": {{json .Message}}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func parseNotifierTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func renderNotifierTemplate(tmpl *template.Template, event NotificationEvent) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// newNotifierSender builds the sender for a notifier configuration
+func newNotifierSender(cfg NotifierConfig) (notifierSender, error) {
+	if cfg.Type == notifierWebhook {
+		return newWebhookNotifier(cfg)
+	}
+
+	message, err := newMessageRenderer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: notifierTimeout(cfg)}
+
+	switch cfg.Type {
+	case notifierSlack:
+		return &slackNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierDiscord:
+		return &discordNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierTelegram:
+		return &telegramNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierMatrix:
+		return &matrixNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierNtfy:
+		return &ntfyNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierGotify:
+		return &gotifyNotifier{cfg: cfg, message: message, client: client}, nil
+	case notifierEmail:
+		return &emailNotifier{cfg: cfg, message: message}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+}
+
+// webhookNotifier posts events to an HTTP endpoint
+type webhookNotifier struct {
+	cfg    NotifierConfig
+	body   *template.Template
+	client *http.Client
+}
+
+func newWebhookNotifier(cfg NotifierConfig) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: notifierTimeout(cfg)},
+	}
+	if cfg.BodyTemplate != "" {
+		body, err := parseNotifierTemplate(cfg.Name, cfg.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_template: %v", err)
+		}
+		n.body = body
+	}
+	return n, nil
+}
+
+func (n *webhookNotifier) Send(event NotificationEvent) error {
+	var body []byte
+	if n.body != nil {
+		rendered, err := renderNotifierTemplate(n.body, event)
+		if err != nil {
+			return permanentError{err}
+		}
+		body = []byte(rendered)
+	} else {
+		var err error
+		if body, err = json.Marshal(event); err != nil {
+			return permanentError{err}
+		}
+	}
+
+	method := n.cfg.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, value := range n.cfg.Headers {
+		headers[key] = value
+	}
+
+	return postNotification(n.client, method, n.cfg.URL, headers, body)
+}
+
+// postNotification sends a request, treating 5xx and 429 responses as
+// worth retrying and any other non-2xx response as permanent
+func postNotification(client *http.Client, method, target string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{fmt.Errorf("failed to create request: %v", err)}
+	}
+	req.Header.Set("User-Agent", "ddns-pilot")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return permanentError{err}
+}
+
+func notifierTimeout(cfg NotifierConfig) time.Duration {
+	if cfg.Timeout > 0 {
+		return time.Duration(cfg.Timeout) * time.Second
+	}
+	return defaultNotifierTimeout * time.Second
+}
+
+// NotificationManager turns update results into events and delivers them
+// to every subscribed notifier in the background
+type NotificationManager struct {
+	config  *AppConfig
+	history *HistoryStore
+
+	mutex   sync.Mutex
+	failing map[string]bool // Records whose last update failed
+	pending sync.WaitGroup
+}
+
+func NewNotificationManager(config *AppConfig, history *HistoryStore) *NotificationManager {
+	return &NotificationManager{
+		config:  config,
+		history: history,
+		failing: make(map[string]bool),
+	}
+}
+
+// HandleResult raises the events an update result implies. Failures are
+// only reported when a record starts failing, not on every retry.
+func (nm *NotificationManager) HandleResult(record *DDNSRecord, result *UpdateResult) {
+	event := NotificationEvent{
+		Time:       result.UpdatedAt,
+		RecordName: result.RecordName,
+		OldIP:      result.OldIP,
+		NewIP:      result.NewIP,
+		Message:    result.Message,
+		Success:    result.Success,
+	}
+	if record != nil {
+		event.RecordType = record.Type()
+	}
+
+	wasFailing := nm.setFailing(result.RecordName, result.RecordType, !result.Success)
+
+	switch {
+	case !result.Success:
+		if !wasFailing {
+			nm.Dispatch(eventUpdateFailed, event)
+		}
+	default:
+		if wasFailing {
+			nm.Dispatch(eventRecovered, event)
+		}
+		if result.Changed {
+			nm.Dispatch(eventIPChanged, event)
+		}
+	}
+}
+
+// HandleDrift raises a drift event for every drifted record
+func (nm *NotificationManager) HandleDrift(reports []*DriftReport) {
+	for _, report := range reports {
+		if report.InSync || report.Error != "" {
+			continue
+		}
+
+		fields := make([]string, 0, len(report.Drift))
+		for _, field := range report.Drift {
+			fields = append(fields, fmt.Sprintf("%s: expected %s, found %s", field.Field, field.Expected, field.Actual))
+		}
+		message := "Record is missing at CloudFlare"
+		if report.Exists {
+			message = "Drift detected - " + strings.Join(fields, "; ")
+		}
+
+		nm.Dispatch(eventDriftDetected, NotificationEvent{
+			Time:       report.CheckedAt,
+			RecordName: report.RecordName,
+			Message:    message,
+		})
+	}
+}
+
+// setFailing records a record's state and returns the previous one, which
+// after a restart comes from the update history
+func (nm *NotificationManager) setFailing(recordName, recordType string, failing bool) bool {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	key := recordKey(recordName, recordType)
+	was, known := nm.failing[key]
+	if !known && nm.history != nil {
+		// The result being handled is already in the history; look past it
+		if entries, err := nm.history.Query(HistoryFilter{RecordName: recordName, RecordType: recordType, Limit: 2}); err == nil && len(entries) == 2 {
+			was = !entries[1].Success
+		}
+	}
+	nm.failing[key] = failing
+	return was
+}
+
+// Dispatch sends an event to every enabled, subscribed notifier
+func (nm *NotificationManager) Dispatch(eventName string, event NotificationEvent) {
+	event.Event = eventName
+	for _, cfg := range nm.config.Notifiers {
+		if !cfg.Enabled || !cfg.Subscribed(eventName) || !cfg.Covers(event.RecordName) {
+			continue
+		}
+
+		nm.pending.Add(1)
+		go func(cfg NotifierConfig) {
+			defer nm.pending.Done()
+			if err := nm.deliver(cfg, event); err != nil {
+				slog.Error("Notification failed", "notifier", cfg.Name, "event", eventName, "error", err)
+			}
+		}(cfg)
+	}
+}
+
+// SendTest delivers a test event to one notifier, without retries, and
+// waits for the result
+func (nm *NotificationManager) SendTest(name string) error {
+	for _, cfg := range nm.config.Notifiers {
+		if cfg.Name == name {
+			sender, err := newNotifierSender(cfg)
+			if err != nil {
+				return err
+			}
+			return sender.Send(NotificationEvent{
+				Event:      eventTest,
+				Time:       time.Now(),
+				RecordName: "test.example.com",
+				RecordType: defaultRecordType,
+				OldIP:      "192.0.2.1",
+				NewIP:      "192.0.2.2",
+				Message:    "Test notification from DDNS Pilot",
+				Success:    true,
+			})
+		}
+	}
+	return fmt.Errorf("notifier %q not found", name)
+}
+
+// Wait blocks until queued notifications have been delivered or given up
+func (nm *NotificationManager) Wait() {
+	nm.pending.Wait()
+}
+
+// deliver sends an event, retrying transient failures with backoff
+func (nm *NotificationManager) deliver(cfg NotifierConfig, event NotificationEvent) error {
+	sender, err := newNotifierSender(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = nm.withRetries(cfg, func() error {
+		return sender.Send(event)
+	})
+	if err == nil {
+		slog.Info("Notification sent", "notifier", cfg.Name, "event", event.Event, "record", event.RecordName)
+	}
+	return err
+}
+
+// withRetries calls send until it succeeds, fails permanently or runs out
+// of the notifier's retries, doubling the wait between attempts
+func (nm *NotificationManager) withRetries(cfg NotifierConfig, send func() error) error {
+	retries := defaultNotifierRetries
+	if cfg.Retries != nil {
+		retries = *cfg.Retries
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(permanentError); permanent || attempt >= retries {
+			return err
+		}
+		slog.Warn("Notification attempt failed, retrying", "notifier", cfg.Name, "attempt", attempt+1, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// validateNotifiers checks notifier settings
+func validateNotifiers(notifiers []NotifierConfig, errs *ValidationErrors) {
+	seen := make(map[string]bool)
+	for i, cfg := range notifiers {
+		path := fmt.Sprintf("$.notifiers[%d]", i)
+
+		if cfg.Name == "" {
+			errs.add(path+".name", "must not be empty")
+		} else if seen[cfg.Name] {
+			errs.add(path+".name", "duplicate notifier name %q", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		for j, event := range cfg.Events {
+			known := false
+			for _, e := range notificationEvents {
+				if event == e {
+					known = true
+				}
+			}
+			if !known {
+				errs.add(fmt.Sprintf("%s.events[%d]", path, j), "unknown event %q (use %s)", event, strings.Join(notificationEvents, ", "))
+			}
+		}
+
+		if cfg.Retries != nil && (*cfg.Retries < 0 || *cfg.Retries > 10) {
+			errs.add(path+".retries", "must be between 0 and 10, got %d", *cfg.Retries)
+		}
+		if cfg.Timeout < 0 {
+			errs.add(path+".timeout", "must not be negative, got %d", cfg.Timeout)
+		}
+
+		for j, name := range cfg.Records {
+			if err := validateHostname(name); err != nil {
+				errs.add(fmt.Sprintf("%s.records[%d]", path, j), "%v", err)
+			}
+		}
+
+		if cfg.MessageTemplate != "" {
+			if _, err := parseNotifierTemplate(cfg.Name, cfg.MessageTemplate); err != nil {
+				errs.add(path+".message_template", "%v", err)
+			}
+		}
+
+		required := func(field, value string) {
+			if value == "" {
+				errs.add(path+"."+field, "required for %s notifiers", cfg.Type)
+			}
+		}
+		optionalURL := func(field, value string) {
+			if value != "" {
+				validateNotifierURL(path+"."+field, value, errs)
+			}
+		}
+
+		switch cfg.Type {
+		case notifierWebhook:
+			validateNotifierURL(path+".url", cfg.URL, errs)
+			if cfg.BodyTemplate != "" {
+				if _, err := parseNotifierTemplate(cfg.Name, cfg.BodyTemplate); err != nil {
+					errs.add(path+".body_template", "%v", err)
+				}
+			}
+		case notifierSlack, notifierDiscord:
+			validateNotifierURL(path+".webhook_url", cfg.WebhookURL, errs)
+		case notifierTelegram:
+			required("token", cfg.Token)
+			required("chat_id", cfg.ChatID)
+			optionalURL("server", cfg.Server)
+		case notifierMatrix:
+			validateNotifierURL(path+".server", cfg.Server, errs)
+			required("token", cfg.Token)
+			required("room_id", cfg.RoomID)
+		case notifierNtfy:
+			required("topic", cfg.Topic)
+			optionalURL("server", cfg.Server)
+			if cfg.Priority < 0 || cfg.Priority > 5 {
+				errs.add(path+".priority", "must be between 1 and 5 for ntfy, got %d", cfg.Priority)
+			}
+		case notifierGotify:
+			validateNotifierURL(path+".server", cfg.Server, errs)
+			required("token", cfg.Token)
+			if cfg.Priority < 0 || cfg.Priority > 10 {
+				errs.add(path+".priority", "must be between 0 and 10 for Gotify, got %d", cfg.Priority)
+			}
+		case notifierEmail:
+			validateEmailNotifier(path, cfg, errs)
+		default:
+			errs.add(path+".type", "unknown notifier type %q (use %s)", cfg.Type, strings.Join(notifierTypes, ", "))
+		}
+	}
+}
+
+func validateNotifierURL(path, value string, errs *ValidationErrors) {
+	if value == "" {
+		errs.add(path, "must not be empty")
+		return
+	}
+	if value == redactedValue {
+		// A redacted export; the import takes the current URL
+		return
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs.add(path, "must be an http(s) URL")
+	}
+}
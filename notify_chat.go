@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultTelegramServer = "https://api.telegram.org"
+	defaultNtfyServer     = "https://ntfy.sh"
+)
+
+// defaultMessageTemplates are used by chat notifiers without a
+// message_template of their own
+var defaultMessageTemplates = map[string]string{
+	eventIPChanged:     "🔄 {{.RecordName}} changed from {{.OldIP}} to {{.NewIP}}",
+	eventUpdateFailed:  "❌ Updating {{.RecordName}} failed: {{.Message}}",
+	eventRecovered:     "✅ {{.RecordName}} is updating again ({{.NewIP}})",
+	eventDriftDetected: "⚠️ {{.RecordName}}: {{.Message}}",
+	eventTest:          "🧪 {{.Message}} ({{.RecordName}}: {{.OldIP}} → {{.NewIP}})",
+}
+
+// eventTitles head the message where the service supports a title
+var eventTitles = map[string]string{
+	eventIPChanged:     "IP changed",
+	eventUpdateFailed:  "Update failed",
+	eventRecovered:     "Update recovered",
+	eventDriftDetected: "Drift detected",
+	eventTest:          "Test notification",
+}
+
+// messageRenderer produces the text of a chat notification
+type messageRenderer struct {
+	custom   *template.Template
+	defaults map[string]*template.Template
+}
+
+func newMessageRenderer(cfg NotifierConfig) (*messageRenderer, error) {
+	renderer := &messageRenderer{defaults: make(map[string]*template.Template)}
+
+	if cfg.MessageTemplate != "" {
+		custom, err := parseNotifierTemplate(cfg.Name, cfg.MessageTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message_template: %v", err)
+		}
+		renderer.custom = custom
+		return renderer, nil
+	}
+
+	for event, text := range defaultMessageTemplates {
+		renderer.defaults[event] = template.Must(parseNotifierTemplate(event, text))
+	}
+	return renderer, nil
+}
+
+func (m *messageRenderer) Render(event NotificationEvent) (string, error) {
+	tmpl := m.custom
+	if tmpl == nil {
+		tmpl = m.defaults[event.Event]
+	}
+	if tmpl == nil {
+		return event.Message, nil
+	}
+	text, err := renderNotifierTemplate(tmpl, event)
+	if err != nil {
+		return "", permanentError{err}
+	}
+	return text, nil
+}
+
+func eventTitle(event NotificationEvent) string {
+	return "DDNS Pilot: " + eventTitles[event.Event]
+}
+
+func postJSON(client *http.Client, target string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanentError{err}
+	}
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["Content-Type"] = "application/json"
+	return postNotification(client, "POST", target, headers, body)
+}
+
+func serverURL(server, fallback string) string {
+	if server == "" {
+		server = fallback
+	}
+	return strings.TrimSuffix(server, "/")
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *slackNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(n.client, n.cfg.WebhookURL, nil, map[string]string{"text": text})
+}
+
+// discordNotifier posts to a Discord channel webhook
+type discordNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *discordNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(n.client, n.cfg.WebhookURL, nil, map[string]string{
+		"username": "DDNS Pilot",
+		"content":  text,
+	})
+}
+
+// telegramNotifier sends through a Telegram bot
+type telegramNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *telegramNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s/bot%s/sendMessage", serverURL(n.cfg.Server, defaultTelegramServer), n.cfg.Token)
+	return postJSON(n.client, target, nil, map[string]string{
+		"chat_id": n.cfg.ChatID,
+		"text":    text,
+	})
+}
+
+// matrixNotifier sends a text message to a Matrix room
+type matrixNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *matrixNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+
+	// The transaction ID makes retries of the same message idempotent
+	txnID := fmt.Sprintf("ddns-pilot-%d", event.Time.UnixNano())
+	target := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		serverURL(n.cfg.Server, ""), url.PathEscape(n.cfg.RoomID), txnID)
+
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": text})
+	if err != nil {
+		return permanentError{err}
+	}
+	return postNotification(n.client, "PUT", target, map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer " + n.cfg.Token,
+	}, body)
+}
+
+// ntfyNotifier publishes to an ntfy topic
+type ntfyNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *ntfyNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+		"Title":        eventTitle(event),
+		"Tags":         event.Event,
+	}
+	if n.cfg.Priority > 0 {
+		headers["Priority"] = strconv.Itoa(n.cfg.Priority)
+	}
+	switch {
+	case n.cfg.Token != "":
+		headers["Authorization"] = "Bearer " + n.cfg.Token
+	case n.cfg.Username != "":
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(n.cfg.Username, n.cfg.Password)
+		headers["Authorization"] = req.Header.Get("Authorization")
+	}
+
+	target := serverURL(n.cfg.Server, defaultNtfyServer) + "/" + url.PathEscape(n.cfg.Topic)
+	return postNotification(n.client, "POST", target, headers, []byte(text))
+}
+
+// gotifyNotifier pushes a message to a Gotify server
+type gotifyNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+	client  *http.Client
+}
+
+func (n *gotifyNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+
+	priority := n.cfg.Priority
+	if priority == 0 {
+		priority = 5
+	}
+
+	return postJSON(n.client, serverURL(n.cfg.Server, "")+"/message", map[string]string{
+		"X-Gotify-Key": n.cfg.Token,
+	}, map[string]interface{}{
+		"title":    eventTitle(event),
+		"message":  text,
+		"priority": priority,
+	})
+}
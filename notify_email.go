@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP connection security
+const (
+	smtpSecurityStartTLS = "starttls"
+	smtpSecurityTLS      = "tls"
+	smtpSecurityNone     = "none"
+)
+
+// Digest schedules
+const (
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+const defaultDigestTime = "08:00"
+
+// emailNotifier sends events as plain text mail over SMTP
+type emailNotifier struct {
+	cfg     NotifierConfig
+	message *messageRenderer
+}
+
+func (n *emailNotifier) Send(event NotificationEvent) error {
+	text, err := n.message.Render(event)
+	if err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	body.WriteString(text + "\n\n")
+	fmt.Fprintf(&body, "Record:  %s\n", event.RecordName)
+	if event.RecordType != "" {
+		fmt.Fprintf(&body, "Type:    %s\n", event.RecordType)
+	}
+	if event.OldIP != "" || event.NewIP != "" {
+		fmt.Fprintf(&body, "Old IP:  %s\n", valueOr(event.OldIP, "-"))
+		fmt.Fprintf(&body, "New IP:  %s\n", valueOr(event.NewIP, "-"))
+	}
+	fmt.Fprintf(&body, "Time:    %s\n", event.Time.Format("2006-01-02 15:04:05 MST"))
+	if event.Message != "" && event.Message != text {
+		fmt.Fprintf(&body, "Details: %s\n", event.Message)
+	}
+
+	subject := fmt.Sprintf("[DDNS Pilot] %s: %s", eventTitles[event.Event], event.RecordName)
+	return sendMail(n.cfg, subject, body.String())
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// smtpPort returns the configured port or the usual one for the security mode
+func smtpPort(cfg NotifierConfig) int {
+	if cfg.SMTPPort > 0 {
+		return cfg.SMTPPort
+	}
+	switch cfg.Security {
+	case smtpSecurityTLS:
+		return 465
+	case smtpSecurityNone:
+		return 25
+	default:
+		return 587
+	}
+}
+
+// sendMail delivers one message. Rejections by the server (5xx replies) are
+// permanent; connection problems and 4xx replies are retried.
+func sendMail(cfg NotifierConfig, subject, body string) error {
+	message, err := buildMailMessage(cfg, subject, body)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	timeout := notifierTimeout(cfg)
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(smtpPort(cfg)))
+	dialer := &net.Dialer{Timeout: timeout}
+	tlsConfig := &tls.Config{ServerName: cfg.SMTPHost}
+
+	var conn net.Conn
+	if cfg.Security == smtpSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return smtpError("greeting", err)
+	}
+	defer client.Close()
+
+	if cfg.Security == "" || cfg.Security == smtpSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return permanentError{fmt.Errorf("%s does not offer STARTTLS", addr)}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return smtpError("STARTTLS", err)
+		}
+	}
+
+	if cfg.Username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted
+		// connection to anything but localhost
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.SMTPHost)); err != nil {
+			return smtpError("authentication", err)
+		}
+	}
+
+	if err := client.Mail(cfg.From); err != nil {
+		return smtpError("MAIL FROM", err)
+	}
+	for _, to := range cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return smtpError("RCPT TO "+to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("DATA", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("message", err)
+	}
+
+	return client.Quit()
+}
+
+// smtpError wraps a failed SMTP step, marking 5xx replies as permanent
+func smtpError(step string, err error) error {
+	wrapped := fmt.Errorf("%s failed: %v", step, err)
+	if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code >= 500 {
+		return permanentError{wrapped}
+	}
+	return wrapped
+}
+
+// buildMailMessage renders the headers and quoted-printable text body
+func buildMailMessage(cfg NotifierConfig, subject, body string) ([]byte, error) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "ddns-pilot"
+	}
+
+	var buf bytes.Buffer
+	headers := [][2]string{
+		{"From", cfg.From},
+		{"To", strings.Join(cfg.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%d.%d@%s>", time.Now().UnixNano(), os.Getpid(), hostname)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+		{"Auto-Submitted", "auto-generated"},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// digestSchedule returns the length of a notifier's digest period and the
+// most recent time a digest was due at or before now
+func digestSchedule(cfg NotifierConfig, now time.Time) (days int, due time.Time) {
+	at, err := time.Parse("15:04", valueOr(cfg.DigestTime, defaultDigestTime))
+	if err != nil {
+		at, _ = time.Parse("15:04", defaultDigestTime)
+	}
+
+	due = time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+
+	days = 1
+	if cfg.Digest == digestWeekly {
+		days = 7
+		// Weekly digests go out on Mondays
+		for due.Weekday() != time.Monday {
+			due = due.AddDate(0, 0, -1)
+		}
+	}
+	return days, due
+}
+
+// digestRecord summarizes one record over a digest period
+type digestRecord struct {
+	Name      string
+	Type      string
+	Checks    int
+	Changes   int
+	Failures  int
+	LastIP    string
+	LastError string
+}
+
+// buildDigest summarizes the history of the covered records between since
+// and until, returning the subject and body of the digest
+func buildDigest(cfg NotifierConfig, records []DDNSRecord, entries []HistoryEntry, since, until time.Time) (string, string) {
+	// The A and AAAA record of a dual-stack name are summarized apart
+	summaries := make(map[string]*digestRecord)
+	summary := func(name, recordType string) *digestRecord {
+		key := strings.ToLower(recordKey(name, recordType))
+		if summaries[key] == nil {
+			summaries[key] = &digestRecord{Name: name, Type: recordTypeOrDefault(recordType)}
+		}
+		return summaries[key]
+	}
+	// Entries come newest first
+	var changes []HistoryEntry
+	totalChanges, totalFailures := 0, 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !entry.Time.Before(until) || !cfg.Covers(entry.RecordName) {
+			continue
+		}
+		s := summary(entry.RecordName, entry.RecordType)
+		s.Checks++
+		switch entry.Status() {
+		case "failed":
+			s.Failures++
+			s.LastError = entry.Message
+			totalFailures++
+		case "changed":
+			s.Changes++
+			s.LastIP = entry.NewIP
+			totalChanges++
+			changes = append(changes, entry)
+		default:
+			if entry.NewIP != "" {
+				s.LastIP = entry.NewIP
+			}
+		}
+	}
+
+	// Records without activity are listed too; their current IP wins over
+	// the last one seen in the period
+	for _, record := range records {
+		if !cfg.Covers(record.RecordName) {
+			continue
+		}
+		s := summary(record.RecordName, record.Type())
+		if record.LastIP != "" {
+			s.LastIP = record.LastIP
+		}
+	}
+
+	names := make([]string, 0, len(summaries))
+	for key := range summaries {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	period := "Daily"
+	if cfg.Digest == digestWeekly {
+		period = "Weekly"
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "DDNS Pilot %s digest\n", strings.ToLower(period))
+	fmt.Fprintf(&body, "%s to %s\n\n", since.Format("2006-01-02 15:04"), until.Format("2006-01-02 15:04 MST"))
+	fmt.Fprintf(&body, "IP changes:      %d\n", totalChanges)
+	fmt.Fprintf(&body, "Failed updates:  %d\n\n", totalFailures)
+
+	body.WriteString("Records:\n")
+	if len(names) == 0 {
+		body.WriteString("  (none)\n")
+	}
+	for _, key := range names {
+		s := summaries[key]
+		fmt.Fprintf(&body, "  %s\n", digestLabel(s.Name, s.Type))
+		fmt.Fprintf(&body, "    %d checks, %d changes, %d failures, current IP %s\n", s.Checks, s.Changes, s.Failures, valueOr(s.LastIP, "unknown"))
+		if s.LastError != "" {
+			fmt.Fprintf(&body, "    Last error: %s\n", s.LastError)
+		}
+	}
+
+	if len(changes) > 0 {
+		body.WriteString("\nIP changes:\n")
+		for _, entry := range changes {
+			fmt.Fprintf(&body, "  %s  %s  %s → %s\n", entry.Time.Format("2006-01-02 15:04"), digestLabel(entry.RecordName, entry.RecordType), valueOr(entry.OldIP, "-"), entry.NewIP)
+		}
+	}
+
+	subject := fmt.Sprintf("[DDNS Pilot] %s digest: %d IP changes, %d failed updates", period, totalChanges, totalFailures)
+	return subject, body.String()
+}
+
+// digestLabel names a record in a digest, with its type unless it is A
+func digestLabel(name, recordType string) string {
+	if recordType = recordTypeOrDefault(recordType); recordType != defaultRecordType {
+		return name + " (" + recordType + ")"
+	}
+	return name
+}
+
+// SendDigest mails the digest of one notifier for the period ending at until
+func (nm *NotificationManager) SendDigest(cfg NotifierConfig, until time.Time) error {
+	days, _ := digestSchedule(cfg, until)
+	since := until.AddDate(0, 0, -days)
+
+	var entries []HistoryEntry
+	if nm.history != nil {
+		var err error
+		if entries, err = nm.history.Query(HistoryFilter{Since: since}); err != nil {
+			return permanentError{err}
+		}
+	}
+
+	subject, body := buildDigest(cfg, nm.config.Records, entries, since, until)
+	return nm.withRetries(cfg, func() error {
+		return sendMail(cfg, subject, body)
+	})
+}
+
+// SendDigests mails every configured digest now, for CLI and cron use
+func (nm *NotificationManager) SendDigests() int {
+	configured, failed := 0, 0
+	for _, cfg := range nm.config.Notifiers {
+		if !cfg.Enabled || cfg.Type != notifierEmail || cfg.Digest == "" {
+			continue
+		}
+		configured++
+		if err := nm.SendDigest(cfg, time.Now()); err != nil {
+			slog.Error("Digest failed", "notifier", cfg.Name, "digest", cfg.Digest, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Digest sent", "notifier", cfg.Name, "digest", cfg.Digest)
+	}
+	if configured == 0 {
+		slog.Warn("No enabled email notifier has a digest configured")
+	}
+	return failed
+}
+
+// RunDigests sends scheduled digests while the web interface runs, until
+// ctx is cancelled. A digest that fell due while the process was down is
+// skipped, not sent late.
+func (nm *NotificationManager) RunDigests(ctx context.Context) {
+	sent := make(map[string]time.Time)
+	check := func() {
+		now := time.Now()
+		for _, cfg := range nm.config.Notifiers {
+			if !cfg.Enabled || cfg.Type != notifierEmail || cfg.Digest == "" {
+				continue
+			}
+			_, due := digestSchedule(cfg, now)
+			last, seen := sent[cfg.Name]
+			sent[cfg.Name] = due
+			if !seen || !due.After(last) {
+				continue
+			}
+
+			if err := nm.SendDigest(cfg, due); err != nil {
+				slog.Error("Digest failed", "notifier", cfg.Name, "digest", cfg.Digest, "error", err)
+			} else {
+				slog.Info("Digest sent", "notifier", cfg.Name, "digest", cfg.Digest)
+			}
+		}
+	}
+
+	check()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validateEmailNotifier checks the SMTP and digest settings of a notifier
+func validateEmailNotifier(path string, cfg NotifierConfig, errs *ValidationErrors) {
+	if cfg.SMTPHost == "" {
+		errs.add(path+".smtp_host", "required for email notifiers")
+	}
+	if cfg.SMTPPort < 0 || cfg.SMTPPort > 65535 {
+		errs.add(path+".smtp_port", "must be between 1 and 65535, got %d", cfg.SMTPPort)
+	}
+	switch cfg.Security {
+	case "", smtpSecurityStartTLS, smtpSecurityTLS, smtpSecurityNone:
+	default:
+		errs.add(path+".security", "unknown security %q (use %s, %s or %s)", cfg.Security, smtpSecurityStartTLS, smtpSecurityTLS, smtpSecurityNone)
+	}
+
+	if cfg.From == "" {
+		errs.add(path+".from", "required for email notifiers")
+	} else if strings.ContainsAny(cfg.From, "<>\r\n ") || !strings.Contains(cfg.From, "@") {
+		errs.add(path+".from", "must be a plain address like ddns@example.com, got %q", cfg.From)
+	}
+	if len(cfg.To) == 0 {
+		errs.add(path+".to", "needs at least one address")
+	}
+	for j, to := range cfg.To {
+		if strings.ContainsAny(to, "<>\r\n ,") || !strings.Contains(to, "@") {
+			errs.add(fmt.Sprintf("%s.to[%d]", path, j), "must be a plain address like admin@example.com, got %q", to)
+		}
+	}
+
+	switch cfg.Digest {
+	case "", digestDaily, digestWeekly:
+	default:
+		errs.add(path+".digest", "unknown digest %q (use %s or %s)", cfg.Digest, digestDaily, digestWeekly)
+	}
+	if cfg.DigestTime != "" {
+		if _, err := time.Parse("15:04", cfg.DigestTime); err != nil {
+			errs.add(path+".digest_time", "must be HH:MM, got %q", cfg.DigestTime)
+		}
+	}
+}
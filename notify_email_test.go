@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is a minimal SMTP server that keeps every message it receives
+type smtpSink struct {
+	listener net.Listener
+	messages chan string
+}
+
+// newSMTPSink listens on a free local port until the test ends
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sink := &smtpSink{listener: listener, messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch verb {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.messages <- data.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// receive returns the subject and decoded body of the next message
+func (s *smtpSink) receive(t *testing.T) (string, string) {
+	t.Helper()
+	select {
+	case data := <-s.messages:
+		msg, err := mail.ReadMessage(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("unreadable message: %v", err)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return subject, strings.ReplaceAll(string(body), "\r\n", "\n")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return "", ""
+	}
+}
+
+func (s *smtpSink) notifier() NotifierConfig {
+	retries := 0
+	return NotifierConfig{
+		Name:     "mail",
+		Type:     notifierEmail,
+		Enabled:  true,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: s.listener.Addr().(*net.TCPAddr).Port,
+		Security: smtpSecurityNone,
+		From:     "ddns@example.com",
+		To:       []string{"admin@example.com"},
+		Digest:   digestDaily,
+		Retries:  &retries,
+		Timeout:  5,
+	}
+}
+
+func TestEmailNotifierSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	sender, err := newNotifierSender(sink.notifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := NotificationEvent{
+		Event:      eventIPChanged,
+		Time:       time.Now(),
+		RecordName: "home.example.com",
+		RecordType: "AAAA",
+		OldIP:      "2001:db8::1",
+		NewIP:      "2001:db8::2",
+		Success:    true,
+	}
+	if err := sender.Send(event); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	subject, body := sink.receive(t)
+	if !strings.Contains(subject, "home.example.com") {
+		t.Errorf("subject %q does not name the record", subject)
+	}
+	if !strings.Contains(body, "2001:db8::2") {
+		t.Errorf("body does not contain the new IP:\n%s", body)
+	}
+}
+
+func TestSendDigestSeparatesRecordTypes(t *testing.T) {
+	sink := newSMTPSink(t)
+	cfg := sink.notifier()
+
+	until := time.Now()
+	history := newTestHistory(t, 0, 0)
+	entries := []HistoryEntry{
+		{Time: until.Add(-3 * time.Hour), RecordName: "home.example.com", RecordType: "A", Action: "update", Success: true, Changed: true, OldIP: "192.0.2.1", NewIP: "192.0.2.2"},
+		{Time: until.Add(-2 * time.Hour), RecordName: "home.example.com", RecordType: "AAAA", Action: "update", Success: false, Message: "timeout"},
+		{Time: until.Add(-time.Hour), RecordName: "home.example.com", RecordType: "AAAA", Action: "update", Success: true, Changed: true, OldIP: "2001:db8::1", NewIP: "2001:db8::2"},
+	}
+	for _, entry := range entries {
+		if err := history.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &AppConfig{
+		Records: []DDNSRecord{
+			{RecordName: "home.example.com", RecordType: "A", Enabled: true, LastIP: "192.0.2.2"},
+			{RecordName: "home.example.com", RecordType: "AAAA", Enabled: true, LastIP: "2001:db8::2"},
+		},
+		Notifiers: []NotifierConfig{cfg},
+	}
+	nm := NewNotificationManager(config, history)
+	if err := nm.SendDigest(cfg, until); err != nil {
+		t.Fatalf("SendDigest: %v", err)
+	}
+
+	subject, body := sink.receive(t)
+	if !strings.Contains(subject, "2 IP changes, 1 failed updates") {
+		t.Errorf("subject = %q", subject)
+	}
+	for _, want := range []string{
+		"  home.example.com\n    1 checks, 1 changes, 0 failures, current IP 192.0.2.2\n",
+		"  home.example.com (AAAA)\n    2 checks, 1 changes, 1 failures, current IP 2001:db8::2\n",
+		"home.example.com (AAAA)  2001:db8::1 → 2001:db8::2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedUnixPeers in web.trusted_proxies trusts clients on a Unix socket,
+// which have no address of their own
+const trustedUnixPeers = "unix"
+
+// forwardedHTTPS marks requests a trusted proxy received over HTTPS
+type forwardedHTTPS struct{}
+
+// URL prefixes an absolute path such as "/login" with web.base_path
+func (c *AppConfig) URL(path string) string {
+	return c.Web.BasePath + path
+}
+
+// validateProxy checks web.trusted_proxies and web.base_path
+func validateProxy(c *AppConfig, errs *ValidationErrors) {
+	for i, entry := range c.Web.TrustedProxies {
+		if _, err := parseTrustedProxy(entry); err != nil {
+			errs.add(fmt.Sprintf("$.web.trusted_proxies[%d]", i), "%v", err)
+		}
+	}
+
+	base := c.Web.BasePath
+	switch {
+	case base == "":
+	case !strings.HasPrefix(base, "/") || strings.HasSuffix(base, "/"):
+		errs.add("$.web.base_path", "must start with / and not end with /, such as /ddns, got %q", base)
+	case strings.ContainsAny(base, "?#\"'<> "):
+		errs.add("$.web.base_path", "must be a plain path, got %q", base)
+	}
+}
+
+// parseTrustedProxy reads a CIDR, a single IP or "unix". A nil network
+// stands for Unix socket peers.
+func parseTrustedProxy(entry string) (*net.IPNet, error) {
+	if entry == trustedUnixPeers {
+		return nil, nil
+	}
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("must be a CIDR such as 10.0.0.0/8, an IP or %q, got %q", trustedUnixPeers, entry)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// trustedProxies is the parsed form of web.trusted_proxies
+type trustedProxies struct {
+	networks []*net.IPNet
+	unix     bool
+}
+
+func newTrustedProxies(entries []string) *trustedProxies {
+	trusted := &trustedProxies{}
+	for _, entry := range entries {
+		network, err := parseTrustedProxy(entry)
+		switch {
+		case err != nil:
+			// Rejected by validation
+		case network == nil:
+			trusted.unix = true
+		default:
+			trusted.networks = append(trusted.networks, network)
+		}
+	}
+	return trusted
+}
+
+// contains reports whether an address, as found in RemoteAddr or a
+// forwarding header, belongs to a trusted proxy
+func (t *trustedProxies) contains(address string) bool {
+	if address == "" || address == "@" {
+		return t.unix
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTrustedProxies replaces RemoteAddr with the client address reported
+// by a trusted proxy, so rate limiting, the audit log and the logs see the
+// real client. Headers from anyone else are ignored: they are trivial to
+// forge.
+func (p *DDNSPilot) withTrustedProxies(next http.Handler) http.Handler {
+	if len(p.config.Web.TrustedProxies) == 0 {
+		return next
+	}
+
+	trusted := newTrustedProxies(p.config.Web.TrustedProxies)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !trusted.contains(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hops, proto := forwardedFor(r.Header)
+		// The last hop is the one our proxy added; walk back past any
+		// further trusted proxies to the first address we can't vouch for
+		for i := len(hops) - 1; i >= 0; i-- {
+			r.RemoteAddr = hops[i]
+			if !trusted.contains(hops[i]) {
+				break
+			}
+		}
+
+		if strings.EqualFold(proto, "https") {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedHTTPS{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the chain of client addresses a request passed
+// through, from Forwarded, X-Forwarded-For or X-Real-IP in that order of
+// preference, and the scheme the first proxy received it on
+func forwardedFor(header http.Header) (hops []string, proto string) {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					if host := forwardedHost(value); host != "" {
+						hops = append(hops, host)
+					}
+				case "proto":
+					if proto == "" {
+						proto = value
+					}
+				}
+			}
+		}
+		return hops, proto
+	}
+
+	proto, _, _ = strings.Cut(header.Get("X-Forwarded-Proto"), ",")
+	proto = strings.TrimSpace(proto)
+
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+			if host := forwardedHost(strings.TrimSpace(hop)); host != "" {
+				hops = append(hops, host)
+			}
+		}
+		return hops, proto
+	}
+
+	if host := forwardedHost(strings.TrimSpace(header.Get("X-Real-IP"))); host != "" {
+		hops = append(hops, host)
+	}
+	return hops, proto
+}
+
+// forwardedHost reduces "1.2.3.4", "1.2.3.4:5678", "[2001:db8::1]:5678" or
+// "2001:db8::1" to the IP. Obfuscated and unknown identifiers give "".
+func forwardedHost(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	if net.ParseIP(value) == nil {
+		return ""
+	}
+	return value
+}
+
+// isHTTPS reports whether the browser is talking HTTPS, to us or to a
+// trusted proxy in front of us, and cookies should be marked Secure
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	forwarded, _ := r.Context().Value(forwardedHTTPS{}).(bool)
+	return forwarded
+}
+
+// withBasePath serves the web interface under web.base_path. Requests are
+// accepted with or without the prefix, so the proxy may pass the path
+// through unchanged or strip it; links always include it.
+func (p *DDNSPilot) withBasePath(next http.Handler) http.Handler {
+	base := p.config.Web.BasePath
+	if base == "" {
+		return next
+	}
+
+	stripped := http.StripPrefix(base, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == base:
+			target := base + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestForwardedHost(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{"203.0.113.7:4711", "203.0.113.7"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"[2001:db8::1]:4711", "2001:db8::1"},
+		{"unknown", ""},
+		{"_hidden", ""},
+		{"example.com", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := forwardedHost(tt.value); got != tt.want {
+			t.Errorf("forwardedHost(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    map[string][]string
+		wantHops  []string
+		wantProto string
+	}{
+		{
+			name:     "X-Forwarded-For chain",
+			header:   map[string][]string{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2"}},
+			wantHops: []string{"198.51.100.1", "10.0.0.2"},
+		},
+		{
+			name:     "X-Forwarded-For over several headers",
+			header:   map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}},
+			wantHops: []string{"198.51.100.1", "10.0.0.2"},
+		},
+		{
+			name:     "X-Forwarded-For skips garbage",
+			header:   map[string][]string{"X-Forwarded-For": {"nonsense, 198.51.100.1,,[2001:db8::1]:80"}},
+			wantHops: []string{"198.51.100.1", "2001:db8::1"},
+		},
+		{
+			name:      "X-Forwarded-Proto takes the first value",
+			header:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https, http"}},
+			wantHops:  []string{"198.51.100.1"},
+			wantProto: "https",
+		},
+		{
+			name:     "X-Real-IP as a last resort",
+			header:   map[string][]string{"X-Real-Ip": {"198.51.100.1"}},
+			wantHops: []string{"198.51.100.1"},
+		},
+		{
+			name:     "Forwarded wins over X-Forwarded-For",
+			header:   map[string][]string{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"192.0.2.66"}},
+			wantHops: []string{"198.51.100.1"},
+		},
+		{
+			name:      "Forwarded with quoted IPv6 and port",
+			header:    map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711";proto=https`}},
+			wantHops:  []string{"2001:db8:cafe::17"},
+			wantProto: "https",
+		},
+		{
+			name:      "Forwarded elements and parameters in any case",
+			header:    map[string][]string{"Forwarded": {`For=192.0.2.60;Proto=http;by=203.0.113.43, for="198.51.100.17"`}},
+			wantHops:  []string{"192.0.2.60", "198.51.100.17"},
+			wantProto: "http",
+		},
+		{
+			name:     "Forwarded over several headers",
+			header:   map[string][]string{"Forwarded": {"for=192.0.2.60", "for=10.0.0.2"}},
+			wantHops: []string{"192.0.2.60", "10.0.0.2"},
+		},
+		{
+			name:     "Forwarded obfuscated identifiers are dropped",
+			header:   map[string][]string{"Forwarded": {`for=unknown, for="_gazonk", for=198.51.100.1`}},
+			wantHops: []string{"198.51.100.1"},
+		},
+		{
+			name:   "no headers",
+			header: map[string][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, values := range tt.header {
+				for _, value := range values {
+					header.Add(key, value)
+				}
+			}
+
+			hops, proto := forwardedFor(header)
+			if !reflect.DeepEqual(hops, tt.wantHops) {
+				t.Errorf("hops = %q, want %q", hops, tt.wantHops)
+			}
+			if proto != tt.wantProto {
+				t.Errorf("proto = %q, want %q", proto, tt.wantProto)
+			}
+		})
+	}
+}
+
+func TestWithTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		header     map[string]string
+		wantClient string
+		wantHTTPS  bool
+	}{
+		{
+			name:       "client behind a trusted proxy",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.2:5000",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			wantClient: "198.51.100.1",
+		},
+		{
+			name:       "untrusted peer's headers are ignored",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "192.0.2.66:5000",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			wantClient: "192.0.2.66",
+		},
+		{
+			name:       "spoofed entries left of the real client are ignored",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.2:5000",
+			header:     map[string]string{"X-Forwarded-For": "127.0.0.1, 198.51.100.1"},
+			wantClient: "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies is walked back",
+			trusted:    []string{"10.0.0.0/8", "172.16.0.1"},
+			remoteAddr: "10.0.0.2:5000",
+			header:     map[string]string{"X-Forwarded-For": "192.0.2.66, 198.51.100.1, 172.16.0.1, 10.0.0.3"},
+			wantClient: "198.51.100.1",
+		},
+		{
+			name:       "only trusted proxies in the chain gives the leftmost",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.2:5000",
+			header:     map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"},
+			wantClient: "10.0.0.4",
+		},
+		{
+			name:       "trusted proxy without headers",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.2:5000",
+			wantClient: "10.0.0.2",
+		},
+		{
+			name:       "Forwarded with IPv6 and https",
+			trusted:    []string{"::1"},
+			remoteAddr: "[::1]:5000",
+			header:     map[string]string{"Forwarded": `for="[2001:db8::7]:4711";proto=https`},
+			wantClient: "2001:db8::7",
+			wantHTTPS:  true,
+		},
+		{
+			name:       "Unix socket peer trusted with unix",
+			trusted:    []string{"unix"},
+			remoteAddr: "@",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "HTTPS"},
+			wantClient: "198.51.100.1",
+			wantHTTPS:  true,
+		},
+		{
+			name:       "Unix socket peer not trusted without unix",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "@",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			wantClient: "@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newDefaultConfig()
+			config.Web.TrustedProxies = tt.trusted
+			p := &DDNSPilot{config: config}
+
+			var gotClient string
+			var gotHTTPS bool
+			handler := p.withTrustedProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotClient, gotHTTPS = clientIP(r), isHTTPS(r)
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if gotClient != tt.wantClient {
+				t.Errorf("client = %q, want %q", gotClient, tt.wantClient)
+			}
+			if gotHTTPS != tt.wantHTTPS {
+				t.Errorf("https = %v, want %v", gotHTTPS, tt.wantHTTPS)
+			}
+		})
+	}
+}
+
+func TestValidateProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		basePath string
+		want     []string
+	}{
+		{name: "valid", trusted: []string{"10.0.0.0/8", "192.0.2.1", "::1", "unix"}, basePath: "/ddns"},
+		{name: "bad proxy", trusted: []string{"10.0.0.0/8", "proxy.lan"}, want: []string{"$.web.trusted_proxies[1]"}},
+		{name: "base path without leading slash", basePath: "ddns", want: []string{"$.web.base_path"}},
+		{name: "base path with trailing slash", basePath: "/ddns/", want: []string{"$.web.base_path"}},
+		{name: "base path with query", basePath: "/ddns?x", want: []string{"$.web.base_path"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newDefaultConfig()
+			config.Web.TrustedProxies = tt.trusted
+			config.Web.BasePath = tt.basePath
+
+			var errs ValidationErrors
+			validateProxy(config, &errs)
+
+			var paths []string
+			for _, problem := range errs {
+				paths = append(paths, problem.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("problems at %q, want %q", paths, tt.want)
+			}
+		})
+	}
+}
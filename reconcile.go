@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// DriftField describes a single mismatch between config and CloudFlare
+type DriftField struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// DriftReport represents the reconcile result for a single record
+type DriftReport struct {
+	RecordName string       `json:"record_name"`
+	RecordType string       `json:"record_type"`
+	Enabled    bool         `json:"enabled"`
+	Exists     bool         `json:"exists"`
+	InSync     bool         `json:"in_sync"`
+	Drift      []DriftField `json:"drift"`
+	Error      string       `json:"error,omitempty"`
+	CheckedAt  time.Time    `json:"checked_at"`
+}
+
+// Fixable reports whether FixDrift can bring the record back in sync.
+// Disabled records are only reported: a fix would point them at the public
+// IP.
+func (r *DriftReport) Fixable() bool {
+	return r.Error == "" && !r.InSync && r.Enabled
+}
+
+// expectedTTL returns the TTL CloudFlare reports for a record we manage.
+// Proxied records always report TTL 1 ("automatic").
+func expectedTTL(record *DDNSRecord) int {
+	if record.Proxied {
+		return 1
+	}
+	return defaultRecordTTL
+}
+
+// CheckDrift compares every configured record against CloudFlare and the
+// current public IP, and remembers the report for the dashboard
+func (dm *DDNSManager) CheckDrift(ctx context.Context) []*DriftReport {
+	// Look up each address family at most once per pass
+	publicIPs := make(map[string]string)
+	publicIPFor := func(recordType string) string {
+		if ip, ok := publicIPs[recordType]; ok {
+			return ip
+		}
+		ip, err := dm.GetPublicIPFor(ctx, recordType)
+		if err != nil {
+			slog.Warn("Drift check could not determine public IP", "record_type", recordType, "error", err)
+		}
+		publicIPs[recordType] = ip
+		return ip
+	}
+
+	reports := make([]*DriftReport, 0, len(dm.config.Records))
+	for i := range dm.config.Records {
+		record := &dm.config.Records[i]
+		reports = append(reports, dm.checkRecordDrift(ctx, record, publicIPFor(record.Type())))
+	}
+
+	dm.driftMutex.Lock()
+	dm.lastDrift = reports
+	dm.lastDriftAt = time.Now()
+	dm.driftMutex.Unlock()
+
+	dm.notifications.HandleDrift(reports)
+
+	return reports
+}
+
+// LastDriftReport returns the most recent drift report and when it was taken
+func (dm *DDNSManager) LastDriftReport() ([]*DriftReport, time.Time) {
+	dm.driftMutex.RLock()
+	defer dm.driftMutex.RUnlock()
+	return dm.lastDrift, dm.lastDriftAt
+}
+
+// checkRecordDrift builds the drift report for one record. An empty
+// publicIP skips the content comparison.
+func (dm *DDNSManager) checkRecordDrift(ctx context.Context, record *DDNSRecord, publicIP string) *DriftReport {
+	report := &DriftReport{
+		RecordName: record.RecordName,
+		RecordType: record.Type(),
+		Enabled:    record.Enabled,
+		CheckedAt:  time.Now(),
+	}
+
+	if record.ZoneID == "" || record.RecordID == "" || record.APIToken == "" {
+		report.Error = "Record configuration incomplete (zone ID, record ID or API token missing)"
+		return report
+	}
+
+	actual, err := dm.GetDNSRecord(ctx, record.APIToken, record.ZoneID, record.RecordID)
+	if err != nil {
+		report.Error = fmt.Sprintf("Failed to fetch record: %v", err)
+		return report
+	}
+
+	if actual == nil {
+		report.Drift = append(report.Drift, DriftField{Field: "exists", Expected: "true", Actual: "false"})
+		return report
+	}
+	report.Exists = true
+
+	addDrift := func(field, expected, actual string) {
+		if expected != actual {
+			report.Drift = append(report.Drift, DriftField{Field: field, Expected: expected, Actual: actual})
+		}
+	}
+
+	addDrift("name", record.RecordName, actual.Name)
+	addDrift("type", record.Type(), actual.Type)
+	addDrift("ttl", strconv.Itoa(expectedTTL(record)), strconv.Itoa(actual.TTL))
+	addDrift("proxied", strconv.FormatBool(record.Proxied), strconv.FormatBool(actual.Proxied))
+
+	// Disabled records are not expected to follow the public IP
+	if record.Enabled && publicIP != "" {
+		addDrift("content", publicIP, actual.Content)
+	}
+	if record.LastIP != "" {
+		addDrift("last_ip", record.LastIP, actual.Content)
+	}
+
+	report.InSync = len(report.Drift) == 0
+	return report
+}
+
+// FixDrift rewrites a record at CloudFlare so it matches the config and the
+// current public IP, recreating it if it was deleted
+func (dm *DDNSManager) FixDrift(ctx context.Context, recordName, recordType string) *UpdateResult {
+	result := &UpdateResult{
+		RecordName: recordName,
+		RecordType: recordType,
+		UpdatedAt:  time.Now(),
+	}
+
+	if !dm.updating.TryLock() {
+		result.Message = updateRunningMessage
+		return result
+	}
+	defer dm.updating.Unlock()
+
+	record, err := dm.config.GetRecord(recordName, recordType)
+	if err != nil {
+		result.Message = fmt.Sprintf("Record not found: %v", err)
+		return result
+	}
+	result.RecordType = record.Type()
+	if !record.Enabled {
+		result.Message = "Record is disabled - enable it to fix drift"
+		return result
+	}
+	defer dm.recordResult(historyActionFixDrift, record, result)
+
+	if record.ZoneID == "" || record.APIToken == "" {
+		result.Message = "Missing zone ID or API token - record configuration incomplete"
+		return result
+	}
+
+	newIP, err := dm.GetPublicIPFor(ctx, record.Type())
+	if err != nil {
+		result.Message = fmt.Sprintf("Failed to get public IP: %v", err)
+		return result
+	}
+	result.NewIP = newIP
+
+	var actual *CloudFlareRecord
+	if record.RecordID != "" {
+		actual, err = dm.GetDNSRecord(ctx, record.APIToken, record.ZoneID, record.RecordID)
+		if err != nil {
+			result.Message = fmt.Sprintf("Failed to fetch record: %v", err)
+			return result
+		}
+	}
+
+	if actual == nil {
+		slog.Info("Recreating missing record", "record", record.RecordName, "zone", record.ZoneID)
+		created, err := dm.CreateDNSRecord(ctx, record.APIToken, record.ZoneID, CloudFlareRecord{
+			Type:    record.Type(),
+			Name:    record.RecordName,
+			Content: newIP,
+			TTL:     defaultRecordTTL,
+			Proxied: record.Proxied,
+		})
+		if err != nil {
+			result.Message = fmt.Sprintf("Failed to recreate record: %v", err)
+			return result
+		}
+		record.RecordID = created.ID
+		result.OldIP = "missing"
+		result.Message = "DNS record recreated"
+	} else {
+		slog.Info("Fixing drift", "record", record.RecordName, "zone", record.ZoneID)
+		result.OldIP = actual.Content
+		if err := dm.putRecord(ctx, record, newIP); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+		result.Message = "DNS record reconciled"
+	}
+
+	result.Success = true
+	result.Changed = result.OldIP != newIP
+	record.LastIP = newIP
+	record.LastUpdated = result.UpdatedAt.Format(time.RFC3339)
+
+	if err := dm.config.save(); err != nil {
+		result.Message += fmt.Sprintf(" (Warning: failed to save config: %v)", err)
+	}
+
+	// Refresh this record's entry in the cached report
+	refreshed := dm.checkRecordDrift(ctx, record, newIP)
+	dm.driftMutex.Lock()
+	for i, report := range dm.lastDrift {
+		if recordKey(report.RecordName, report.RecordType) == record.Key() {
+			dm.lastDrift[i] = refreshed
+		}
+	}
+	dm.driftMutex.Unlock()
+
+	return result
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// stubCloudFlare serves the given records by ID and points the API at the
+// stub for the duration of the test. It returns the requests that weren't
+// reads.
+func stubCloudFlare(t *testing.T, records map[string]CloudFlareRecord) func() []string {
+	t.Helper()
+	var mutex sync.Mutex
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			mutex.Lock()
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			mutex.Unlock()
+		}
+		for id, record := range records {
+			if r.URL.Path == "/zones/zone1/dns_records/"+id {
+				result, _ := json.Marshal(record)
+				json.NewEncoder(w).Encode(CloudFlareResponse{Success: true, Result: result})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(CloudFlareResponse{Errors: []CloudFlareError{{Code: 81044, Message: "Record not found"}}})
+	}))
+	t.Cleanup(server.Close)
+
+	previous := cloudflareAPIBase
+	cloudflareAPIBase = server.URL
+	t.Cleanup(func() { cloudflareAPIBase = previous })
+
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return writes
+	}
+}
+
+func TestCheckRecordDrift(t *testing.T) {
+	stubCloudFlare(t, map[string]CloudFlareRecord{
+		"rec1": {ID: "rec1", Name: "home.example.com", Type: "A", Content: "203.0.113.7", TTL: 300},
+		"rec2": {ID: "rec2", Name: "home.example.com", Type: "A", Content: "198.51.100.1", TTL: 1, Proxied: true},
+	})
+
+	record := func(edit func(r *DDNSRecord)) *DDNSRecord {
+		r := &DDNSRecord{
+			RecordName: "home.example.com",
+			APIToken:   "token",
+			ZoneID:     "zone1",
+			RecordID:   "rec1",
+			Enabled:    true,
+			LastIP:     "203.0.113.7",
+		}
+		edit(r)
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		record   *DDNSRecord
+		publicIP string
+		want     []DriftField
+		exists   bool
+		wantErr  bool
+	}{
+		{
+			name:     "in sync",
+			record:   record(func(r *DDNSRecord) {}),
+			publicIP: "203.0.113.7",
+			exists:   true,
+		},
+		{
+			name:     "public IP moved on",
+			record:   record(func(r *DDNSRecord) {}),
+			publicIP: "203.0.113.8",
+			want:     []DriftField{{"content", "203.0.113.8", "203.0.113.7"}},
+			exists:   true,
+		},
+		{
+			name:   "unknown public IP skips the content",
+			record: record(func(r *DDNSRecord) {}),
+			exists: true,
+		},
+		{
+			name:     "changed outside ddns-pilot",
+			record:   record(func(r *DDNSRecord) { r.RecordID = "rec2" }),
+			publicIP: "203.0.113.7",
+			want: []DriftField{
+				{"ttl", "300", "1"},
+				{"proxied", "false", "true"},
+				{"content", "203.0.113.7", "198.51.100.1"},
+				{"last_ip", "203.0.113.7", "198.51.100.1"},
+			},
+			exists: true,
+		},
+		{
+			name:     "proxied records expect automatic TTL",
+			record:   record(func(r *DDNSRecord) { r.RecordID = "rec2"; r.Proxied = true; r.LastIP = "198.51.100.1" }),
+			publicIP: "198.51.100.1",
+			exists:   true,
+		},
+		{
+			name:     "disabled records don't follow the public IP",
+			record:   record(func(r *DDNSRecord) { r.Enabled = false }),
+			publicIP: "203.0.113.8",
+			exists:   true,
+		},
+		{
+			name:     "disabled records changed outside ddns-pilot are not fixable",
+			record:   record(func(r *DDNSRecord) { r.RecordID = "rec2"; r.Enabled = false }),
+			publicIP: "203.0.113.7",
+			want: []DriftField{
+				{"ttl", "300", "1"},
+				{"proxied", "false", "true"},
+				{"last_ip", "203.0.113.7", "198.51.100.1"},
+			},
+			exists: true,
+		},
+		{
+			name:     "renamed at CloudFlare",
+			record:   record(func(r *DDNSRecord) { r.RecordName = "office.example.com" }),
+			publicIP: "203.0.113.7",
+			want:     []DriftField{{"name", "office.example.com", "home.example.com"}},
+			exists:   true,
+		},
+		{
+			name:     "deleted at CloudFlare",
+			record:   record(func(r *DDNSRecord) { r.RecordID = "gone" }),
+			publicIP: "203.0.113.7",
+			want:     []DriftField{{"exists", "true", "false"}},
+		},
+		{
+			name:     "incomplete record",
+			record:   record(func(r *DDNSRecord) { r.APIToken = "" }),
+			publicIP: "203.0.113.7",
+			wantErr:  true,
+		},
+	}
+
+	dm := NewDDNSManager(&AppConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := dm.checkRecordDrift(context.Background(), tt.record, tt.publicIP)
+
+			if (report.Error != "") != tt.wantErr {
+				t.Fatalf("error = %q, want error %v", report.Error, tt.wantErr)
+			}
+			if !reflect.DeepEqual(report.Drift, tt.want) {
+				t.Errorf("drift = %+v, want %+v", report.Drift, tt.want)
+			}
+			if report.Exists != tt.exists {
+				t.Errorf("exists = %v, want %v", report.Exists, tt.exists)
+			}
+			inSync := !tt.wantErr && tt.want == nil
+			if report.InSync != inSync {
+				t.Errorf("in sync = %v, want %v", report.InSync, inSync)
+			}
+			if report.Fixable() != (!tt.wantErr && !inSync && tt.record.Enabled) {
+				t.Errorf("fixable = %v with drift %+v", report.Fixable(), report.Drift)
+			}
+		})
+	}
+}
+
+// TestFixDriftDisabledRecord checks that a disabled record is left alone
+// instead of being pointed at the public IP
+func TestFixDriftDisabledRecord(t *testing.T) {
+	writes := stubCloudFlare(t, map[string]CloudFlareRecord{
+		"rec1": {ID: "rec1", Name: "home.example.com", Type: "A", Content: "198.51.100.1", TTL: 1, Proxied: true},
+	})
+	previous := configPath
+	configPath = filepath.Join(t.TempDir(), "ddns-pilot.json")
+	t.Cleanup(func() { configPath = previous })
+
+	config := newDefaultConfig()
+	config.Records = []DDNSRecord{{
+		RecordName: "home.example.com",
+		APIToken:   "token",
+		ZoneID:     "zone1",
+		RecordID:   "rec1",
+		LastIP:     "203.0.113.7",
+	}}
+	dm := NewDDNSManager(config)
+
+	result := dm.FixDrift(context.Background(), "home.example.com", "A")
+	if result.Success {
+		t.Errorf("fixed a disabled record: %s", result.Message)
+	}
+	if got := writes(); len(got) != 0 {
+		t.Errorf("disabled record was written: %v", got)
+	}
+	if got := config.Records[0].LastIP; got != "203.0.113.7" {
+		t.Errorf("last IP = %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long in-flight updates and requests get to finish
+// after SIGINT or SIGTERM before they are aborted
+const shutdownGrace = 30 * time.Second
+
+// shutdownContexts ties two contexts to SIGINT and SIGTERM. stopping is
+// cancelled by the first signal: nothing new is started from then on. work
+// is cancelled once the grace period has passed, or by a second signal, and
+// aborts whatever is still talking to the IP source, DNS or CloudFlare.
+func shutdownContexts() (stopping, work context.Context) {
+	stopping, stop := context.WithCancel(context.Background())
+	work, abort := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		slog.Info("Shutting down", "signal", sig.String(), "grace", shutdownGrace.String())
+		stop()
+
+		select {
+		case sig = <-signals:
+			slog.Warn("Aborting in-flight work", "signal", sig.String())
+		case <-time.After(shutdownGrace):
+			slog.Warn("Grace period over, aborting in-flight work")
+		}
+		abort()
+	}()
+
+	return stopping, work
+}
+
+// finishShutdown runs once the servers and background routines have
+// stopped: it lets pending notifications go out, writes the config so the
+// last IPs and update times survive the restart, and closes the log file
+func (p *DDNSPilot) finishShutdown() {
+	sent := make(chan struct{})
+	go func() {
+		p.ddns.notifications.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-p.ctx.Done():
+		// Aborted: only give notifications already on their way a moment
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			slog.Warn("Gave up waiting for notifications")
+		}
+	}
+
+	if err := p.config.save(); err != nil {
+		slog.Error("Failed to save config", "error", err)
+	}
+
+	slog.Info("Shutdown complete")
+	if logFile != nil {
+		logFile.Close()
+	}
+}
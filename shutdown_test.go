@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestShutdownContexts checks that the first signal only stops new work and
+// a second one aborts what is still running
+func TestShutdownContexts(t *testing.T) {
+	stopping, work := shutdownContexts()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	select {
+	case <-stopping.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("first signal did not cancel the stopping context")
+	}
+	select {
+	case <-work.Done():
+		t.Fatal("first signal aborted in-flight work")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	select {
+	case <-work.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not abort in-flight work")
+	}
+}
+
+// newShutdownPilot returns a pilot whose config is saved to a temporary
+// file, and that file's path
+func newShutdownPilot(t *testing.T, ctx context.Context) (*DDNSPilot, string) {
+	t.Helper()
+	previous := configPath
+	configPath = filepath.Join(t.TempDir(), "ddns-pilot.json")
+	t.Cleanup(func() { configPath = previous })
+
+	config := newDefaultConfig()
+	return &DDNSPilot{config: config, ddns: NewDDNSManager(config), stopping: ctx, ctx: ctx}, configPath
+}
+
+// TestFinishShutdownOrder checks that the config is written only after
+// pending notifications went out
+func TestFinishShutdownOrder(t *testing.T) {
+	p, path := newShutdownPilot(t, context.Background())
+	p.ddns.notifications.pending.Add(1)
+
+	done := make(chan struct{})
+	go func() {
+		p.finishShutdown()
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("config written while a notification was pending (stat error %v)", err)
+	}
+
+	p.ddns.notifications.pending.Done()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish after the notification was sent")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("config not written on shutdown: %v", err)
+	}
+}
+
+// TestFinishShutdownAborted checks that an aborted shutdown stops waiting
+// for notifications and still writes the config
+func TestFinishShutdownAborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, path := newShutdownPilot(t, ctx)
+	p.ddns.notifications.pending.Add(1)
+	defer p.ddns.notifications.pending.Done()
+
+	done := make(chan struct{})
+	go func() {
+		p.finishShutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborted shutdown kept waiting for a notification")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("config not written on shutdown: %v", err)
+	}
+}
@@ -1,16 +1,322 @@
-// Auto-refresh functionality for DDNS Pilot
-function setupAutoRefresh(enabled, intervalMinutes) {
-    if (enabled && intervalMinutes > 0) {
-        setTimeout(function() {
-            window.location.reload();
-        }, intervalMinutes * 60 * 1000);
+// Live dashboard for DDNS Pilot: update results and IP changes arrive over
+// Server-Sent Events from /api/v1/events and are drawn in place. Without
+// EventSource support the dashboard keeps working with plain form posts.
+
+var timelineLength = 10;
+
+// appURL prefixes an absolute path with the base path the page was served
+// under (web.base_path), which the server puts in <body data-base-path>
+function appURL(path) {
+    return (document.body.getAttribute('data-base-path') || '') + path;
+}
+
+function initLiveDashboard() {
+    // Drop ?update_result=... so a refresh doesn't repeat an old message
+    if (window.location.search && window.history.replaceState) {
+        window.history.replaceState(null, '', window.location.pathname);
     }
+
+    if (!window.EventSource) {
+        setLiveStatus('offline', 'Unavailable');
+        return;
+    }
+
+    var source = new EventSource(appURL('/api/v1/events?types=cycle_started,cycle_finished,record_result,public_ip'));
+    source.onopen = function() {
+        setLiveStatus('live', '● Live');
+    };
+    source.onerror = function() {
+        setLiveStatus('offline', 'Reconnecting…');
+    };
+
+    source.addEventListener('cycle_started', function() {
+        setLiveStatus('busy', '🔄 Updating…');
+    });
+    source.addEventListener('cycle_finished', function() {
+        setLiveStatus('live', '● Live');
+    });
+    source.addEventListener('record_result', function(e) {
+        updateRecordRow(JSON.parse(e.data).data);
+    });
+    source.addEventListener('public_ip', function(e) {
+        var data = JSON.parse(e.data).data;
+        var currentIP = document.getElementById('current-ip');
+        if (currentIP && data.type === 'A') {
+            currentIP.textContent = data.ip;
+        }
+    });
+
+    interceptLiveForms();
 }
 
-// Initialize auto-refresh based on server configuration
-// This will be called from templates that need auto-refresh
-function initAutoRefresh(config) {
-    if (config && config.AutoUpdate && config.UpdateInterval) {
-        setupAutoRefresh(true, config.UpdateInterval);
+function setLiveStatus(state, text) {
+    var status = document.getElementById('live-status');
+    if (status) {
+        status.className = 'live-status live-' + state;
+        status.textContent = text;
+    }
+}
+
+// findRecordRow returns the dashboard row of a record, if it is shown. Rows
+// are keyed like the server's recordKey: the name for A records and
+// name:TYPE for others, since a name can have both.
+function findRecordRow(recordName, recordType) {
+    var key = !recordType || recordType === 'A' ? recordName : recordName + ':' + recordType;
+    var rows = document.querySelectorAll('tr[data-record]');
+    for (var i = 0; i < rows.length; i++) {
+        if (rows[i].getAttribute('data-record') === key) {
+            return rows[i];
+        }
+    }
+    return null;
+}
+
+function updateRecordRow(result) {
+    var row = findRecordRow(result.record, result.type);
+    if (!row) {
+        return;
+    }
+
+    if (result.last_ip) {
+        var ipCell = row.querySelector('.cell-last-ip');
+        ipCell.textContent = '';
+        var ip = document.createElement('span');
+        ip.className = 'last-ip';
+        ip.textContent = result.last_ip;
+        ipCell.appendChild(ip);
+    }
+    if (result.last_updated) {
+        row.querySelector('.cell-last-updated').textContent = result.last_updated;
+    }
+
+    var timeline = row.querySelector('.timeline');
+    if (timeline) {
+        var empty = timeline.querySelector('em');
+        if (empty) {
+            timeline.removeChild(empty);
+        }
+        var dot = document.createElement('span');
+        dot.className = 'timeline-dot timeline-' + result.status;
+        var title = result.time.replace('T', ' ').substring(0, 19) + ' - ' + result.message;
+        if (result.changed) {
+            title += ' (' + result.old_ip + ' → ' + result.new_ip + ')';
+        }
+        dot.title = title;
+        timeline.appendChild(dot);
+        var dots = timeline.querySelectorAll('.timeline-dot');
+        for (var i = 0; i < dots.length - timelineLength; i++) {
+            timeline.removeChild(dots[i]);
+        }
+    }
+
+    // Restart the highlight animation
+    row.classList.remove('row-flash');
+    void row.offsetWidth;
+    row.classList.add('row-flash');
+}
+
+// interceptLiveForms posts the update buttons in the background; the rows
+// redraw from the event stream and the outcome shows as an alert
+function interceptLiveForms() {
+    if (!window.fetch) {
+        return;
+    }
+
+    var forms = document.querySelectorAll('form[data-live]');
+    for (var i = 0; i < forms.length; i++) {
+        forms[i].addEventListener('submit', submitLiveForm);
+    }
+}
+
+function submitLiveForm(e) {
+    var form = e.target;
+    var button = form.querySelector('button');
+    e.preventDefault();
+    button.disabled = true;
+
+    fetch(form.action, {
+        method: 'POST',
+        body: new URLSearchParams(new FormData(form)),
+        credentials: 'same-origin',
+        headers: {
+            'Accept': 'application/json',
+            'X-Requested-With': 'XMLHttpRequest'
+        }
+    }).then(function(response) {
+        if (!response.ok) {
+            throw new Error('HTTP ' + response.status);
+        }
+        return response.json();
+    }).then(function(data) {
+        if (data.status === 'busy') {
+            showLiveAlert('warning', '⏳ ' + data.message);
+            return;
+        }
+        // Update All answers with a list, a single update with one result
+        showUpdateResults(data.status ? (data.results || []) : [data]);
+    }).catch(function(err) {
+        showLiveAlert('error', '❌ Update request failed: ' + err.message);
+    }).then(function() {
+        button.disabled = false;
+    });
+}
+
+function showUpdateResults(results) {
+    var failed = results.filter(function(result) { return !result.Success; });
+
+    if (results.length === 0) {
+        showLiveAlert('warning', '⚠️ No enabled records to update');
+    } else if (results.length === 1) {
+        var result = results[0];
+        if (result.Success) {
+            showLiveAlert('success', '✅ ' + result.RecordName + ': ' + result.Message + (result.NewIP ? ' (' + result.NewIP + ')' : ''));
+        } else {
+            showLiveAlert('error', '❌ Failed to update ' + result.RecordName + ': ' + result.Message);
+        }
+    } else if (failed.length > 0) {
+        showLiveAlert('warning', '⚠️ Mixed results: ' + (results.length - failed.length) + ' successful, ' + failed.length + ' failed');
+    } else {
+        showLiveAlert('success', '✅ Successfully updated ' + results.length + ' record(s)');
     }
-} 
\ No newline at end of file
+}
+
+function showLiveAlert(type, message) {
+    var container = document.getElementById('live-alerts');
+    if (!container) {
+        return;
+    }
+
+    var alert = document.createElement('div');
+    alert.className = 'alert alert-' + type + ' alert-dismissible';
+    alert.textContent = message;
+
+    var close = document.createElement('button');
+    close.type = 'button';
+    close.className = 'close';
+    close.innerHTML = '&times;';
+    close.onclick = function() {
+        container.removeChild(alert);
+    };
+    alert.appendChild(close);
+
+    container.textContent = '';
+    container.appendChild(alert);
+}
+
+// Log viewer: new entries arrive as "log" events and are appended when they
+// pass the page's level and record filters
+
+var logLevels = { DEBUG: -4, INFO: 0, WARN: 4, ERROR: 8 };
+
+function initLogTail(limit) {
+    var tbody = document.getElementById('log-entries');
+    var toggle = document.getElementById('live-tail');
+    var level = document.getElementById('log-level').value.toUpperCase();
+    var record = document.getElementById('log-record').value;
+    var lastID = parseInt(tbody.getAttribute('data-last-id'), 10) || 0;
+    var source = null;
+
+    function matches(entry) {
+        if (level && logLevels[entry.level] < logLevels[level]) {
+            return false;
+        }
+        return !record || (entry.record || '').toLowerCase() === record.toLowerCase();
+    }
+
+    function append(entry) {
+        if (entry.id <= lastID) {
+            return;
+        }
+        lastID = entry.id;
+        if (!matches(entry)) {
+            return;
+        }
+
+        var view = document.getElementById('log-view');
+        var atBottom = view.scrollTop + view.clientHeight >= view.scrollHeight - 5;
+
+        tbody.appendChild(logEntryRow(entry));
+        while (limit > 0 && tbody.rows.length > limit) {
+            tbody.removeChild(tbody.rows[0]);
+        }
+        if (atBottom) {
+            view.scrollTop = view.scrollHeight;
+        }
+    }
+
+    function start() {
+        // Fetch whatever arrived while paused, then follow the stream
+        var query = '?after=' + lastID + '&level=' + encodeURIComponent(level.toLowerCase()) + '&record=' + encodeURIComponent(record);
+        fetch(appURL('/api/v1/logs') + query, { credentials: 'same-origin' })
+            .then(function(response) { return response.json(); })
+            .then(function(data) { data.entries.forEach(append); })
+            .catch(function() {});
+
+        source = new EventSource(appURL('/api/v1/events?types=log'));
+        source.addEventListener('log', function(e) {
+            append(JSON.parse(e.data).data);
+        });
+    }
+
+    function stop() {
+        if (source) {
+            source.close();
+            source = null;
+        }
+    }
+
+    toggle.addEventListener('change', function() {
+        if (toggle.checked) {
+            start();
+        } else {
+            stop();
+        }
+    });
+
+    var view = document.getElementById('log-view');
+    view.scrollTop = view.scrollHeight;
+    if (window.EventSource && window.fetch) {
+        start();
+    } else {
+        toggle.disabled = true;
+        toggle.checked = false;
+    }
+}
+
+function logEntryRow(entry) {
+    var row = document.createElement('tr');
+    row.className = 'log-' + entry.level.toLowerCase();
+
+    function cell(text, className) {
+        var td = document.createElement('td');
+        if (className) {
+            td.className = className;
+        }
+        td.textContent = text;
+        row.appendChild(td);
+        return td;
+    }
+
+    cell(entry.time.replace('T', ' ').substring(0, 19), 'log-time');
+    var levelCell = cell('');
+    var levelLabel = document.createElement('span');
+    levelLabel.className = 'log-level';
+    levelLabel.textContent = entry.level;
+    levelCell.appendChild(levelLabel);
+    cell(entry.record || '', 'record-name');
+    cell(entry.message);
+
+    var details = cell('');
+    Object.keys(entry.fields || {}).sort().forEach(function(key) {
+        var field = document.createElement('span');
+        field.className = 'log-field';
+        field.appendChild(document.createTextNode(key + '='));
+        var value = document.createElement('code');
+        value.textContent = entry.fields[key];
+        field.appendChild(value);
+        details.appendChild(field);
+        details.appendChild(document.createTextNode(' '));
+    });
+
+    return row;
+}
@@ -3,8 +3,9 @@ package main
 import (
 	"embed"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 )
 
 //go:embed templates/*
@@ -15,18 +16,39 @@ var staticFS embed.FS
 
 var templates *template.Template
 
-func initTemplates() {
+// initTemplates parses the templates. urlFor is exposed to them as url, so
+// links honor web.base_path: {{url "/settings"}}.
+func initTemplates(urlFor func(string) string) {
 	var err error
-	templates, err = template.ParseFS(templateFS, "templates/*.html")
+	templates, err = template.New("").Funcs(template.FuncMap{
+		"url": urlFor,
+	}).ParseFS(templateFS, "templates/*.html")
 	if err != nil {
-		log.Fatalf("Failed to parse templates: %v", err)
+		slog.Error("Failed to parse templates", "error", err)
+		os.Exit(1)
 	}
 }
 
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	err := templates.ExecuteTemplate(w, tmpl, data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
+// page is embedded in the data of every template. renderTemplate fills in
+// the request's CSRF token, which forms carry as {{$.CSRFToken}}.
+type page struct {
+	CSRFToken string
+}
+
+func (pg *page) setCSRFToken(token string) {
+	pg.CSRFToken = token
+}
+
+// pageData is a pointer to template data embedding page
+type pageData interface {
+	setCSRFToken(token string)
+}
+
+// renderTemplate renders a page whose forms carry the request's CSRF token
+func (p *DDNSPilot) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data pageData) {
+	data.setCSRFToken(csrfToken(w, r, p.config))
+	if err := templates.ExecuteTemplate(w, tmpl, data); err != nil {
+		slog.Error("Template execution failed", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
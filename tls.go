@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	selfSignedValidity = 365 * 24 * time.Hour
+	selfSignedRenewal  = 30 * 24 * time.Hour // Regenerate when less than this is left
+)
+
+// WebTLSConfig serves the web interface over HTTPS, either with a
+// certificate from files or with a generated self-signed one
+type WebTLSConfig struct {
+	CertFile     string   `json:"cert_file,omitempty"` // PEM certificate chain, reloaded when it changes
+	KeyFile      string   `json:"key_file,omitempty"`  // PEM private key
+	SelfSigned   bool     `json:"self_signed"`         // Generate a certificate, kept next to the config
+	Hosts        []string `json:"hosts,omitempty"`     // Extra names and IPs for the self-signed certificate
+	RedirectPort int      `json:"redirect_port"`       // Plain HTTP port that redirects to HTTPS; 0 turns it off
+	HSTSMaxAge   int      `json:"hsts_max_age"`        // Seconds; 0 leaves out Strict-Transport-Security
+
+	ACME ACMEConfig `json:"acme"`
+}
+
+// Enabled reports whether the web interface is served over HTTPS
+func (c WebTLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.SelfSigned || c.ACME.Enabled()
+}
+
+// SelfSignedPaths returns where the generated certificate and key are kept
+func (c *AppConfig) SelfSignedPaths() (certFile, keyFile string) {
+	return sidecarPath(configPath, ".tls.crt"), sidecarPath(configPath, ".tls.key")
+}
+
+// validateWebTLS checks the HTTPS settings
+func validateWebTLS(c *AppConfig, errs *ValidationErrors) {
+	cfg := c.Web.TLS
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		errs.add("$.web.tls", "cert_file and key_file must be set together")
+	}
+	if cfg.SelfSigned && cfg.CertFile != "" {
+		errs.add("$.web.tls.self_signed", "cannot be combined with cert_file")
+	}
+	if cfg.RedirectPort < 0 || cfg.RedirectPort > 65535 {
+		errs.add("$.web.tls.redirect_port", "must be between 0 and 65535, got %d", cfg.RedirectPort)
+	} else if cfg.RedirectPort != 0 && cfg.RedirectPort == c.WebPort() {
+		errs.add("$.web.tls.redirect_port", "must differ from the web port")
+	} else if cfg.RedirectPort != 0 && cfg.Enabled() && len(c.RedirectListenAddresses()) == 0 {
+		errs.add("$.web.tls.redirect_port", "needs a TCP address in web.listen to listen next to; set it to 0 when serving only on Unix sockets")
+	}
+	if cfg.HSTSMaxAge < 0 {
+		errs.add("$.web.tls.hsts_max_age", "must not be negative, got %d", cfg.HSTSMaxAge)
+	}
+	validateACME(c, errs)
+}
+
+// webTLSConfig returns the TLS settings for the web listener, or nil when
+// HTTPS is off
+func (p *DDNSPilot) webTLSConfig() (*tls.Config, error) {
+	cfg := p.config.Web.TLS
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	certs := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if cfg.SelfSigned || cfg.ACME.Enabled() {
+		certFile, keyFile := p.config.SelfSignedPaths()
+		if err := ensureSelfSigned(certFile, keyFile, selfSignedHosts(append(append([]string{}, cfg.Hosts...), cfg.ACME.Domains...))); err != nil {
+			return nil, err
+		}
+		selfSigned := &certReloader{certFile: certFile, keyFile: keyFile}
+		if cfg.ACME.Enabled() {
+			// Serve the self-signed certificate until ACME has issued one
+			_, certs.certFile, certs.keyFile = p.config.ACMEPaths()
+			certs.fallback = selfSigned
+		} else {
+			certs = selfSigned
+		}
+	}
+
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	p.certs = certs
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}, nil
+}
+
+// certReloader serves a certificate from files, reading them again when
+// either changes, so a renewed certificate is picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	fallback *certReloader // Used while the files don't exist yet
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	failed  time.Time // Files that failed to load, warned about once
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTime, err := newestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert == nil && c.fallback != nil {
+			return c.fallback.GetCertificate(nil)
+		}
+		if c.cert != nil {
+			// Mid-renewal, e.g. one file replaced; keep serving the old pair
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// modTime stays put so the next handshake tries again: the
+			// files may be a renewal that is only half written
+			if !modTime.Equal(c.failed) {
+				slog.Warn("Failed to reload certificate, keeping the previous one", "cert_file", c.certFile, "error", err)
+				c.failed = modTime
+			}
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	if c.cert != nil {
+		slog.Info("Reloaded certificate", "cert_file", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+// usingFallback reports whether the fallback certificate is being served
+func (c *certReloader) usingFallback() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cert == nil && c.fallback != nil
+}
+
+func newestModTime(paths ...string) (time.Time, error) {
+	var newest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// selfSignedHosts lists the names the self-signed certificate covers
+func selfSignedHosts(extra []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	return append(hosts, extra...)
+}
+
+// ensureSelfSigned generates a self-signed certificate unless a usable one
+// is already on disk: not close to expiry and covering every host
+func ensureSelfSigned(certFile, keyFile string, hosts []string) error {
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Until(leaf.NotAfter) > selfSignedRenewal && coversHosts(leaf, hosts) {
+			return nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"DDNS Pilot"}, CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := writeKeyPair(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return err
+	}
+
+	slog.Info("Generated self-signed certificate", "cert_file", certFile, "hosts", strings.Join(hosts, ","), "expires", template.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	names := make(map[string]bool)
+	for _, name := range cert.DNSNames {
+		names[strings.ToLower(name)] = true
+	}
+	for _, ip := range cert.IPAddresses {
+		names[ip.String()] = true
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		if !names[strings.ToLower(host)] {
+			return false
+		}
+	}
+	return true
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+// writeKeyPair replaces a certificate and its key. Both go to temporary
+// files first, and the certificate is renamed into place last: the
+// reloader only switches once the files form a matching pair again.
+func writeKeyPair(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	certTmp, keyTmp := certFile+".tmp", keyFile+".tmp"
+	if err := os.WriteFile(keyTmp, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", keyFile, err)
+	}
+	if err := os.WriteFile(certTmp, certPEM, 0644); err != nil {
+		os.Remove(keyTmp)
+		return fmt.Errorf("failed to write %s: %v", certFile, err)
+	}
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		os.Remove(keyTmp)
+		os.Remove(certTmp)
+		return fmt.Errorf("failed to write %s: %v", keyFile, err)
+	}
+	if err := os.Rename(certTmp, certFile); err != nil {
+		os.Remove(certTmp)
+		return fmt.Errorf("failed to write %s: %v", certFile, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file through a rename, so readers never see a
+// half-written file and a crash can't leave one behind. Where a rename
+// would break the setup it writes in place instead: a symlink would become
+// a regular file, a single-file bind mount can't be renamed over (EBUSY),
+// and a directory may not take the temporary file at all.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	writeInPlace := func() error {
+		if err := os.WriteFile(path, data, perm); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		return nil
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return writeInPlace()
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		if os.IsPermission(err) || errors.Is(err, syscall.EROFS) {
+			return writeInPlace()
+		}
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+			return writeInPlace()
+		}
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// withSecurityHeaders adds Strict-Transport-Security to every response. It
+// is left out for self-signed certificates, including the one served until
+// ACME has issued a real one: once a browser has seen the header, it no
+// longer lets the user click through the certificate warning.
+func (p *DDNSPilot) withSecurityHeaders(next http.Handler) http.Handler {
+	cfg := p.config.Web.TLS
+	if !cfg.Enabled() || cfg.SelfSigned || cfg.HSTSMaxAge == 0 {
+		return next
+	}
+
+	hsts := "max-age=" + strconv.Itoa(cfg.HSTSMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && !p.certs.usingFallback() {
+			w.Header().Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectListenAddresses returns where the HTTPS redirect listens: the
+// redirect port on the host of every TCP web listen address, so it is
+// reachable from exactly where the web interface is
+func (c *AppConfig) RedirectListenAddresses() []string {
+	port := strconv.Itoa(c.Web.TLS.RedirectPort)
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, address := range c.WebListenAddresses() {
+		if strings.HasPrefix(address, unixSocketPrefix) {
+			continue
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		if redirect := net.JoinHostPort(host, port); !seen[redirect] {
+			seen[redirect] = true
+			addresses = append(addresses, redirect)
+		}
+	}
+	return addresses
+}
+
+// startRedirectListener answers plain HTTP on the redirect port, sending
+// browsers to HTTPS. Health checks are served directly so probes don't
+// need to trust the certificate.
+func (p *DDNSPilot) startRedirectListener() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.handleHealthz)
+	mux.HandleFunc("/readyz", p.handleReadyz)
+	mux.HandleFunc("/", p.handleHTTPSRedirect)
+
+	var listeners []net.Listener
+	for _, address := range p.config.RedirectListenAddresses() {
+		listener, err := listenOn(address, "")
+		if err != nil {
+			slog.Error("HTTP redirect listener failed", "error", err)
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return
+		}
+		slog.Info("Redirecting HTTP to HTTPS", "address", listener.Addr().String())
+		listeners = append(listeners, listener)
+	}
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-p.stopping.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	serveWeb(server, listeners)
+}
+
+func (p *DDNSPilot) handleHTTPSRedirect(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if port := p.config.WebPort(); port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedPair writes a self-signed pair for host into dir and dates both
+// files at modTime
+func selfSignedPair(t *testing.T, dir, host string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.Remove(certFile)
+	if err := ensureSelfSigned(certFile, keyFile, []string{host}); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	touch(t, modTime, certFile, keyFile)
+	return certFile, keyFile
+}
+
+func touch(t *testing.T, modTime time.Time, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := ensureSelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	first, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode %v, error %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	// A certificate covering the hosts is kept
+	if err := ensureSelfSigned(certFile, keyFile, []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	if again, _ := os.ReadFile(certFile); !bytes.Equal(again, first) {
+		t.Error("certificate regenerated although it covers the hosts")
+	}
+
+	// A new host needs a new certificate
+	if err := ensureSelfSigned(certFile, keyFile, []string{"localhost", "ddns.lan"}); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.VerifyHostname("ddns.lan") != nil {
+		t.Error("regenerated certificate does not cover the new host")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := selfSignedPair(t, dir, "first.lan", start)
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	// served returns the host the served certificate was made for
+	served := func() string {
+		t.Helper()
+		cert, err := certs.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+		if cert.Leaf == nil {
+			t.Fatal("certificate without a parsed leaf")
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	if got := served(); got != "first.lan" {
+		t.Fatalf("serving %s, want first.lan", got)
+	}
+
+	// A renewed pair is picked up
+	selfSignedPair(t, dir, "second.lan", start.Add(time.Minute))
+	if got := served(); got != "second.lan" {
+		t.Errorf("after renewal serving %s, want second.lan", got)
+	}
+
+	// A broken or missing file keeps the previous certificate
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	touch(t, start.Add(2*time.Minute), certFile)
+	if got := served(); got != "second.lan" {
+		t.Errorf("with a broken file serving %s, want second.lan", got)
+	}
+	// A failed load is retried even if the times don't move on, as with a
+	// renewal that was only half written at the first attempt
+	selfSignedPair(t, dir, "retried.lan", start.Add(2*time.Minute))
+	if got := served(); got != "retried.lan" {
+		t.Errorf("after a failed load serving %s, want retried.lan", got)
+	}
+
+	os.Remove(certFile)
+	if got := served(); got != "retried.lan" {
+		t.Errorf("with a missing file serving %s, want retried.lan", got)
+	}
+
+	// Once the files are good again they are loaded
+	selfSignedPair(t, dir, "third.lan", start.Add(3*time.Minute))
+	if got := served(); got != "third.lan" {
+		t.Errorf("after repair serving %s, want third.lan", got)
+	}
+}
+
+func TestCertReloaderFallback(t *testing.T) {
+	dir := t.TempDir()
+	fallbackCert, fallbackKey := selfSignedPair(t, t.TempDir(), "fallback.lan", time.Now())
+	certs := &certReloader{
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+		fallback: &certReloader{certFile: fallbackCert, keyFile: fallbackKey},
+	}
+
+	cert, err := certs.GetCertificate(nil)
+	if err != nil || cert.Leaf.Subject.CommonName != "fallback.lan" || !certs.usingFallback() {
+		t.Fatalf("without files: %v, using fallback %v", err, certs.usingFallback())
+	}
+
+	if err := writeKeyPair(certs.certFile, certs.keyFile, mustReadFile(t, fallbackCert), mustReadFile(t, fallbackKey)); err != nil {
+		t.Fatalf("writeKeyPair: %v", err)
+	}
+	if _, err := certs.GetCertificate(nil); err != nil || certs.usingFallback() {
+		t.Errorf("with files: %v, using fallback %v", err, certs.usingFallback())
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return data
+}
+
+func TestCertReloaderWithoutCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certs := &certReloader{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+	if _, err := certs.GetCertificate(nil); err == nil {
+		t.Error("GetCertificate without files succeeded")
+	}
+
+	os.WriteFile(certs.certFile, []byte("not a certificate"), 0644)
+	os.WriteFile(certs.keyFile, []byte("not a key"), 0600)
+	if _, err := certs.GetCertificate(nil); err == nil {
+		t.Error("GetCertificate with broken files succeeded")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ddns-pilot.json")
+
+	if err := writeFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if got := mustReadFile(t, path); string(got) != "second" {
+		t.Errorf("content = %q, want second", got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind (stat error %v)", err)
+	}
+
+	// A symlinked file is written through the link, which stays a link
+	target := filepath.Join(t.TempDir(), "real.json")
+	os.WriteFile(target, []byte("old"), 0600)
+	link := filepath.Join(dir, "linked.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := writeFileAtomic(link, []byte("new"), 0600); err != nil {
+		t.Fatalf("write through symlink: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink replaced by a regular file (error %v)", err)
+	}
+	if got := mustReadFile(t, target); string(got) != "new" {
+		t.Errorf("symlink target content = %q, want new", got)
+	}
+}
+
+// TestWriteFileAtomicReadOnlyDir checks that a writable file in a directory
+// that won't take the temporary file is still written
+func TestWriteFileAtomicReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions don't apply to root")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ddns-pilot.json")
+	os.WriteFile(path, []byte("old"), 0600)
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	if err := writeFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := mustReadFile(t, path); string(got) != "new" {
+		t.Errorf("content = %q, want new", got)
+	}
+}
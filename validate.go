@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError is a single problem found in a config document
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) String() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found in a config, so they can
+// all be fixed in one pass
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("config has %d problem(s):", len(errs)))
+	for _, err := range errs {
+		lines = append(lines, "  "+err.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs *ValidationErrors) add(path, format string, args ...interface{}) {
+	*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// decodeConfigDocument migrates, strictly validates and decodes a JSON
+// config document. It returns the schema version the document started at.
+func decodeConfigDocument(data []byte) (*AppConfig, int, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if raw == nil {
+		return nil, 0, fmt.Errorf("config must be an object")
+	}
+
+	fromVersion, err := migrateConfigTree(raw)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+
+	// Unknown fields and wrong types are pruned from the document as they
+	// are reported, so the remaining values can still be checked
+	var errs ValidationErrors
+	checkConfigFields("$", raw, reflect.TypeOf(AppConfig{}), &errs)
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+
+	config := newDefaultConfig()
+	if err := json.Unmarshal(migrated, config); err != nil {
+		return nil, fromVersion, err
+	}
+
+	reported := make(map[string]bool, len(errs))
+	for _, problem := range errs {
+		reported[problem.Path] = true
+	}
+	for _, problem := range validateConfig(config) {
+		if !reported[problem.Path] {
+			errs = append(errs, problem)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fromVersion, errs
+	}
+
+	return config, fromVersion, nil
+}
+
+// validateConfigFile checks a config file, with environment overrides
+// applied, without modifying it
+func validateConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	jsonData, err := configDocumentToJSON(path, data)
+	if err != nil {
+		return err
+	}
+	config, _, err := decodeConfigDocument(jsonData)
+	if err != nil {
+		return err
+	}
+	return config.applyEnvOverrides()
+}
+
+// checkConfigFields reports unknown fields and type mismatches by walking a
+// raw document alongside the Go type it decodes into. It returns false if
+// the value itself does not fit the type; offending children are removed.
+func checkConfigFields(path string, value interface{}, t reflect.Type, errs *ValidationErrors) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if value == nil {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected an object, got %s", jsonTypeName(value))
+			return false
+		}
+
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			field, known := fields[key]
+			if !known {
+				if suggestion := closestField(key, fields); suggestion != "" {
+					errs.add(path+"."+key, "unknown field (did you mean %q?)", suggestion)
+				} else {
+					errs.add(path+"."+key, "unknown field")
+				}
+				delete(m, key)
+				continue
+			}
+			if !checkConfigFields(path+"."+key, m[key], field.Type, errs) {
+				delete(m, key)
+			}
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			errs.add(path, "expected a list, got %s", jsonTypeName(value))
+			return false
+		}
+		for i, item := range items {
+			if !checkConfigFields(fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), errs) {
+				items[i] = nil
+			}
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected an object, got %s", jsonTypeName(value))
+			return false
+		}
+		for key, item := range m {
+			if !checkConfigFields(path+"."+key, item, t.Elem(), errs) {
+				delete(m, key)
+			}
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(path, "expected a string, got %s", jsonTypeName(value))
+			return false
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "expected true or false, got %s", jsonTypeName(value))
+			return false
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(float64)
+		if !ok {
+			errs.add(path, "expected a whole number, got %s", jsonTypeName(value))
+			return false
+		}
+		if number != float64(int64(number)) {
+			errs.add(path, "expected a whole number, got %v", number)
+			return false
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			errs.add(path, "expected a number, got %s", jsonTypeName(value))
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonFields maps JSON field names to struct fields, including embedded ones
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range jsonFields(field.Type) {
+				fields[embeddedName] = embedded
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
+
+// closestField suggests the known field a typo was probably meant to be
+func closestField(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 4
+	for name := range fields {
+		if distance := editDistance(strings.ToLower(key), name); distance < bestDistance ||
+			(distance == bestDistance && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// validateConfig checks the semantic rules a decoded config must satisfy
+func validateConfig(c *AppConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if c.Web.Port < 1 || c.Web.Port > 65535 {
+		errs.add("$.web.port", "must be between 1 and 65535, got %d", c.Web.Port)
+	}
+	if c.Web.SessionTimeout < 1 {
+		errs.add("$.web.session_timeout", "must be at least 1 minute, got %d", c.Web.SessionTimeout)
+	}
+	if c.Web.Password == "" {
+		errs.add("$.web.password", "must not be empty")
+	}
+	validateListen(c, &errs)
+	validateProxy(c, &errs)
+	validateWebTLS(c, &errs)
+	if c.UpdateInterval < 1 || c.UpdateInterval > 1440 {
+		errs.add("$.update_interval", "must be between 1 and 1440 minutes, got %d", c.UpdateInterval)
+	}
+	if c.History.RetentionDays < 0 {
+		errs.add("$.history.retention_days", "must not be negative, got %d", c.History.RetentionDays)
+	}
+	if c.History.MaxEntries < 0 {
+		errs.add("$.history.max_entries", "must not be negative, got %d", c.History.MaxEntries)
+	}
+	if c.Audit.RetentionDays < 0 {
+		errs.add("$.audit.retention_days", "must not be negative, got %d", c.Audit.RetentionDays)
+	}
+	if c.Audit.MaxEntries < 0 {
+		errs.add("$.audit.max_entries", "must not be negative, got %d", c.Audit.MaxEntries)
+	}
+	validateNotifiers(c.Notifiers, &errs)
+	validateHooks("$.hooks", &c.Hooks, &errs)
+	validateMQTT(c.MQTT, &errs)
+	if c.Health.StaleAfter < 0 {
+		errs.add("$.health.stale_after", "must not be negative, got %d", c.Health.StaleAfter)
+	}
+	if c.Health.IPCheckInterval < 0 {
+		errs.add("$.health.ip_check_interval", "must not be negative, got %d", c.Health.IPCheckInterval)
+	}
+	if c.Logs.BufferSize < 0 {
+		errs.add("$.logs.buffer_size", "must not be negative, got %d", c.Logs.BufferSize)
+	}
+	if c.Logs.MaxSizeMB < 0 {
+		errs.add("$.logs.max_size_mb", "must not be negative, got %d", c.Logs.MaxSizeMB)
+	}
+	if c.Logs.MaxFiles < 0 {
+		errs.add("$.logs.max_files", "must not be negative, got %d", c.Logs.MaxFiles)
+	}
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			errs.add("$.metrics.listen", "must be an address like :9102 or 127.0.0.1:9102, got %q", c.Metrics.Listen)
+		}
+	}
+
+	seen := make(map[string]int)
+	for i, record := range c.Records {
+		path := fmt.Sprintf("$.records[%d]", i)
+
+		if err := validateHostname(record.RecordName); err != nil {
+			errs.add(path+".record_name", "%v", err)
+		} else if first, duplicate := seen[strings.ToLower(record.RecordName)+"/"+record.Type()]; duplicate {
+			errs.add(path+".record_name", "duplicate of $.records[%d] (%s %s)", first, record.RecordName, record.Type())
+		} else {
+			seen[strings.ToLower(record.RecordName)+"/"+record.Type()] = i
+		}
+
+		if record.RecordType != "" && record.RecordType != "A" && record.RecordType != "AAAA" {
+			errs.add(path+".record_type", "must be A or AAAA, got %q", record.RecordType)
+		}
+		if record.ZoneID == "" {
+			errs.add(path+".zone_id", "missing - re-add the record or use --import-zone to look it up")
+		}
+		if record.RecordID == "" {
+			errs.add(path+".record_id", "missing - re-add the record or use --import-zone to look it up")
+		}
+		if record.APIToken == "" {
+			errs.add(path+".api_token", "missing CloudFlare API token")
+		}
+		if record.LastIP != "" && net.ParseIP(record.LastIP) == nil {
+			errs.add(path+".last_ip", "not an IP address: %q", record.LastIP)
+		}
+		validateHooks(path+".hooks", record.Hooks, &errs)
+	}
+
+	return errs
+}
+
+// validateHostname checks a fully qualified record name, allowing a
+// leading wildcard label
+func validateHostname(name string) error {
+	if name == "" {
+		return fmt.Errorf("must not be empty")
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return fmt.Errorf("hostname longer than 253 characters")
+	}
+
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%q is not a fully qualified hostname (e.g. home.example.com)", name)
+	}
+
+	for i, label := range labels {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("%q has an empty or over-long label", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q has a label starting or ending with '-'", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("%q contains invalid character %q", name, r)
+			}
+		}
+	}
+
+	return nil
+}
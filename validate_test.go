@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testRecordFields completes a record in the documents below
+const testRecordFields = `"zone_id": "z1", "record_id": "r1", "api_token": "t"`
+
+func TestDecodeConfigDocumentStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []ValidationError
+	}{
+		{
+			name: "valid",
+			doc:  `{"schema_version": 1, "auto_update": true, "records": [{"record_name": "home.example.com", "enabled": true, ` + testRecordFields + `}]}`,
+		},
+		{
+			name: "unknown top-level field with a suggestion",
+			doc:  `{"schema_version": 1, "auto_updte": true}`,
+			want: []ValidationError{{"$.auto_updte", `unknown field (did you mean "auto_update"?)`}},
+		},
+		{
+			name: "unknown field without a close match",
+			doc:  `{"schema_version": 1, "colour_scheme": "dark"}`,
+			want: []ValidationError{{"$.colour_scheme", "unknown field"}},
+		},
+		{
+			name: "unknown field in a nested object",
+			doc:  `{"schema_version": 1, "web": {"prot": 8080}}`,
+			want: []ValidationError{{"$.web.prot", `unknown field (did you mean "port"?)`}},
+		},
+		{
+			name: "unknown field in a list item",
+			doc:  `{"schema_version": 1, "records": [{"record_name": "a.example.com", ` + testRecordFields + `}, {"record_name": "b.example.com", "zoneid": "z", ` + testRecordFields + `}]}`,
+			want: []ValidationError{{"$.records[1].zoneid", `unknown field (did you mean "zone_id"?)`}},
+		},
+		{
+			name: "string for a number",
+			doc:  `{"schema_version": 1, "web": {"port": "8080"}}`,
+			want: []ValidationError{{"$.web.port", "expected a whole number, got a string"}},
+		},
+		{
+			name: "fraction for a whole number",
+			doc:  `{"schema_version": 1, "update_interval": 2.5}`,
+			want: []ValidationError{{"$.update_interval", "expected a whole number, got 2.5"}},
+		},
+		{
+			name: "string for a boolean in a list item",
+			doc:  `{"schema_version": 1, "records": [{"record_name": "a.example.com", "enabled": "yes", ` + testRecordFields + `}]}`,
+			want: []ValidationError{{"$.records[0].enabled", "expected true or false, got a string"}},
+		},
+		{
+			name: "object for a list",
+			doc:  `{"schema_version": 1, "records": {"record_name": "a.example.com"}}`,
+			want: []ValidationError{{"$.records", "expected a list, got an object"}},
+		},
+		{
+			name: "list for an object",
+			doc:  `{"schema_version": 1, "web": [8080]}`,
+			want: []ValidationError{{"$.web", "expected an object, got a list"}},
+		},
+		{
+			name: "field and value problems are reported together",
+			doc:  `{"schema_version": 1, "wbe": {}, "web": {"port": 0}}`,
+			want: []ValidationError{
+				{"$.wbe", `unknown field (did you mean "web"?)`},
+				{"$.web.port", "must be between 1 and 65535, got 0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _, err := decodeConfigDocument([]byte(tt.doc))
+
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if config == nil {
+					t.Fatal("no config decoded")
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error = %v, want validation errors", err)
+			}
+			if !reflect.DeepEqual([]ValidationError(errs), tt.want) {
+				t.Errorf("problems:\n%v\nwant:\n%v", errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeConfigDocumentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not JSON", `{"records": [`},
+		{"not an object", `null`},
+		{"newer schema", `{"schema_version": 99}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if config, _, err := decodeConfigDocument([]byte(tt.doc)); err == nil {
+				t.Errorf("decoded %+v, want an error", config)
+			}
+		})
+	}
+}
+
+func TestClosestField(t *testing.T) {
+	fields := jsonFields(reflect.TypeOf(WebConfig{}))
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"prot", "port"},
+		{"Port", "port"},
+		{"pasword", "password"},
+		{"something_else_entirely", ""},
+	}
+
+	for _, tt := range tests {
+		if got := closestField(tt.key, fields); got != tt.want {
+			t.Errorf("closestField(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}